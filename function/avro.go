@@ -0,0 +1,428 @@
+package function
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const avroMediaType = "application/cloudevents+avro"
+
+// avroSchema is the schema events are written with and expected to be read
+// with.  Requests carry no schema of their own.
+// https://github.com/cloudevents/spec/blob/v1.0/avro-format.md
+const avroSchema = `{
+  "namespace": "io.cloudevents",
+  "type": "record",
+  "name": "CloudEvent",
+  "version": "1.0",
+  "fields": [
+    {"name": "attribute", "type": {"type": "map", "values": ["null", "boolean", "int", "string", "bytes"]}},
+    {"name": "data", "type": ["bytes", "null", "boolean",
+      {"type": "map", "values": ["null", "boolean",
+        {"type": "record", "name": "CloudEventData", "fields": [
+          {"name": "value", "type": {"type": "map", "values": ["null", "boolean", "CloudEventData", "double", "string"]}}
+        ]},
+        "double", "string"]},
+      "double", "string"]}
+  ]
+}`
+
+// avroMaxDataDepth limits how deeply data maps may nest, as encoding/json
+// limits nesting, so that a request can't exhaust the stack
+const avroMaxDataDepth = 10000
+
+// Branches of the attribute value union
+const (
+	avroAttrNull = iota
+	avroAttrBoolean
+	avroAttrInt
+	avroAttrString
+	avroAttrBytes
+)
+
+// Branches of the data union
+const (
+	avroDataBytes = iota
+	avroDataNull
+	avroDataBoolean
+	avroDataMap
+	avroDataDouble
+	avroDataString
+)
+
+// Branches of the union of values within data maps
+const (
+	avroValueNull = iota
+	avroValueBoolean
+	avroValueRecord
+	avroValueDouble
+	avroValueString
+)
+
+func init() {
+
+	registerEventFormat(&eventFormat{
+		mediaType:   avroMediaType,
+		contentType: avroMediaType,
+		marshal:     marshalAvro,
+		unmarshal:   unmarshalAvro,
+	})
+
+}
+
+// marshalAvro encodes c as an Avro CloudEvent record.  The format is only
+// defined for v1.0 so events in other versions are translated first.
+func marshalAvro(c *CloudEvent) ([]byte, error) {
+
+	c, err := translateCloudEvent(c, specVersion10)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := make(map[string]interface{})
+	for name, v := range c.Extensions {
+		attributes[name] = v
+	}
+	for _, m := range attributeMappings {
+		if _, ok := m.names[specVersion10]; ok {
+			if v := m.get(c); len(v) > 0 {
+				attributes[m.name] = v
+			}
+		}
+	}
+
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b []byte
+	b = appendAvroLong(b, int64(len(names)))
+
+	for _, name := range names {
+		b = appendAvroString(b, name)
+
+		switch v := attributes[name].(type) {
+		case nil:
+			b = appendAvroLong(b, avroAttrNull)
+		case bool:
+			b = appendAvroBoolean(appendAvroLong(b, avroAttrBoolean), v)
+		case int32:
+			b = appendAvroLong(appendAvroLong(b, avroAttrInt), int64(v))
+		case int:
+			b = appendAvroLong(appendAvroLong(b, avroAttrInt), int64(int32(v)))
+		case int64:
+			b = appendAvroLong(appendAvroLong(b, avroAttrInt), int64(int32(v)))
+		case []byte:
+			b = appendAvroBytes(appendAvroLong(b, avroAttrBytes), v)
+		default:
+			b = appendAvroString(appendAvroLong(b, avroAttrString), extensionString(v))
+		}
+	}
+	if len(names) > 0 {
+		b = appendAvroLong(b, 0)
+	}
+
+	return appendAvroData(b, c)
+}
+
+// appendAvroData appends the data union.  JSON data is written as Avro values
+// where the schema can express it, otherwise data is written as bytes.
+func appendAvroData(b []byte, c *CloudEvent) ([]byte, error) {
+
+	if len(c.Data) == 0 {
+		return appendAvroLong(b, avroDataNull), nil
+	}
+
+	raw, isJSON := jsonData(c)
+	if !isJSON {
+		return appendAvroBytes(appendAvroLong(b, avroDataBytes), c.Data), nil
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+
+	if !isAvroDataValue(data) {
+		return appendAvroBytes(appendAvroLong(b, avroDataBytes), c.Data), nil
+	}
+
+	switch v := data.(type) {
+	case nil:
+		return appendAvroLong(b, avroDataNull), nil
+	case bool:
+		return appendAvroBoolean(appendAvroLong(b, avroDataBoolean), v), nil
+	case float64:
+		return appendAvroDouble(appendAvroLong(b, avroDataDouble), v), nil
+	case string:
+		return appendAvroString(appendAvroLong(b, avroDataString), v), nil
+	}
+
+	return appendAvroDataMap(appendAvroLong(b, avroDataMap), data.(map[string]interface{})), nil
+}
+
+// isAvroDataValue reports whether the decoded JSON value v can be written
+// with the data schema, which has no representation for arrays
+func isAvroDataValue(v interface{}) bool {
+
+	switch t := v.(type) {
+	case []interface{}:
+		return false
+	case map[string]interface{}:
+		for _, member := range t {
+			if !isAvroDataValue(member) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func appendAvroDataMap(b []byte, m map[string]interface{}) []byte {
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b = appendAvroLong(b, int64(len(keys)))
+
+	for _, k := range keys {
+		b = appendAvroString(b, k)
+
+		switch v := m[k].(type) {
+		case nil:
+			b = appendAvroLong(b, avroValueNull)
+		case bool:
+			b = appendAvroBoolean(appendAvroLong(b, avroValueBoolean), v)
+		case float64:
+			b = appendAvroDouble(appendAvroLong(b, avroValueDouble), v)
+		case string:
+			b = appendAvroString(appendAvroLong(b, avroValueString), v)
+		case map[string]interface{}:
+			b = appendAvroDataMap(appendAvroLong(b, avroValueRecord), v)
+		}
+	}
+	if len(keys) > 0 {
+		b = appendAvroLong(b, 0)
+	}
+
+	return b
+}
+
+// unmarshalAvro decodes an Avro CloudEvent record
+func unmarshalAvro(b []byte) (*CloudEvent, error) {
+
+	r := &avroReader{b: b}
+	c := &CloudEvent{}
+	attributes := make(map[string]interface{})
+
+	r.readMap(func(name string) {
+
+		switch r.readLong() {
+		case avroAttrNull:
+		case avroAttrBoolean:
+			attributes[name] = r.readBoolean()
+		case avroAttrInt:
+			attributes[name] = int32(r.readLong())
+		case avroAttrString:
+			attributes[name] = r.readString()
+		case avroAttrBytes:
+			attributes[name] = r.readBytes()
+		default:
+			r.fail("invalid attribute branch for %s", name)
+		}
+	})
+
+	if v, ok := attributes["time"]; ok {
+		c.rawTime = extensionString(v)
+	}
+
+	// Attributes which v1.0 defines are promoted, the rest are extensions
+	for _, m := range attributeMappings {
+		if v, ok := attributes[m.name]; ok {
+			if _, known := m.names[specVersion10]; known {
+				m.set(c, extensionString(v))
+				delete(attributes, m.name)
+			}
+		}
+	}
+
+	if len(attributes) > 0 {
+		c.Extensions = attributes
+	}
+
+	switch r.readLong() {
+	case avroDataBytes:
+		c.Data = r.readBytes()
+	case avroDataNull:
+	case avroDataBoolean:
+		c.Data, _ = json.Marshal(r.readBoolean())
+	case avroDataMap:
+		c.Data, _ = json.Marshal(r.readDataMap(1))
+	case avroDataDouble:
+		c.Data, _ = json.Marshal(r.readDouble())
+	case avroDataString:
+		c.Data, _ = json.Marshal(r.readString())
+	default:
+		r.fail("invalid data branch")
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return c, nil
+}
+
+func appendAvroLong(b []byte, v int64) []byte {
+
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendAvroBytes(b []byte, v []byte) []byte {
+	return append(appendAvroLong(b, int64(len(v))), v...)
+}
+
+func appendAvroString(b []byte, v string) []byte {
+	return appendAvroBytes(b, []byte(v))
+}
+
+func appendAvroBoolean(b []byte, v bool) []byte {
+
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+func appendAvroDouble(b []byte, v float64) []byte {
+
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	return append(b, buf[:]...)
+}
+
+// avroReader decodes Avro binary encoded values from b.  The first error is
+// kept in err, after which every read returns a zero value.
+type avroReader struct {
+	b   []byte
+	err error
+}
+
+func (r *avroReader) fail(format string, args ...interface{}) {
+
+	if r.err == nil {
+		r.err = fmt.Errorf("avro: "+format, args...)
+	}
+	r.b = nil
+}
+
+func (r *avroReader) readLong() int64 {
+
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.fail("malformed long")
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *avroReader) readBytes() []byte {
+
+	length := r.readLong()
+	if length < 0 || int64(len(r.b)) < length {
+		r.fail("malformed length")
+		return nil
+	}
+	v := r.b[:length]
+	r.b = r.b[length:]
+	return v
+}
+
+func (r *avroReader) readString() string {
+	return string(r.readBytes())
+}
+
+func (r *avroReader) readBoolean() bool {
+
+	if len(r.b) < 1 {
+		r.fail("truncated boolean")
+		return false
+	}
+	v := r.b[0] != 0
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *avroReader) readDouble() float64 {
+
+	if len(r.b) < 8 {
+		r.fail("truncated double")
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(r.b))
+	r.b = r.b[8:]
+	return v
+}
+
+// readMap calls fn with the key of each map entry, leaving fn to read the value
+func (r *avroReader) readMap(fn func(key string)) {
+
+	for r.err == nil {
+
+		count := r.readLong()
+		if count == 0 {
+			return
+		}
+
+		// A negative count is followed by the size of the block in bytes
+		if count < 0 {
+			count = -count
+			r.readLong()
+		}
+
+		for ; count > 0 && r.err == nil; count-- {
+			fn(r.readString())
+		}
+	}
+}
+
+// readDataMap reads a map within data, nested depth maps deep
+func (r *avroReader) readDataMap(depth int) map[string]interface{} {
+
+	m := make(map[string]interface{})
+
+	r.readMap(func(key string) {
+
+		switch r.readLong() {
+		case avroValueNull:
+			m[key] = nil
+		case avroValueBoolean:
+			m[key] = r.readBoolean()
+		case avroValueRecord:
+			if depth >= avroMaxDataDepth {
+				r.fail("data nested too deeply")
+				return
+			}
+			m[key] = r.readDataMap(depth + 1)
+		case avroValueDouble:
+			m[key] = r.readDouble()
+		case avroValueString:
+			m[key] = r.readString()
+		default:
+			r.fail("invalid data value branch for %s", key)
+		}
+	})
+
+	return m
+}
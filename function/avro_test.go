@@ -0,0 +1,309 @@
+package function
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sameJSON reports whether a and b hold equal JSON values
+func sameJSON(t *testing.T, a []byte, b []byte) bool {
+
+	t.Helper()
+
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatalf("%s: %s", a, err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func Test_Avro_RoundTrip(t *testing.T) {
+
+	eventTime := time.Date(2018, 4, 5, 17, 31, 0, 123456789, time.UTC)
+
+	c := &CloudEvent{
+		Type:            "com.example.someevent",
+		SpecVersion:     specVersion10,
+		Source:          "/mycontext/subcontext",
+		ID:              "1234-1234-1234",
+		Time:            eventTime,
+		Subject:         "greeting",
+		DataSchema:      "https://example.com/schema",
+		DataContentType: "application/json",
+		Extensions: map[string]interface{}{
+			"boolext":   true,
+			"falseext":  false,
+			"intext":    int32(-42),
+			"minext":    int32(math.MinInt32),
+			"maxext":    int32(math.MaxInt32),
+			"stringext": "value",
+			"bytesext":  []byte{0, 1, 2, 0xff},
+			"uriext":    mustParseURL(t, "https://example.com/x?y=z"),
+			"timeext":   time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+		},
+		Data: []byte(`{"word":"cat","ok":true,"none":null,"n":-1.5,"nested":{"deeper":{"s":"x"}}}`),
+	}
+
+	b, err := marshalAvro(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := unmarshalAvro(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Type != c.Type || got.SpecVersion != c.SpecVersion || got.Source != c.Source || got.ID != c.ID ||
+		got.Subject != c.Subject || got.DataSchema != c.DataSchema || got.DataContentType != c.DataContentType {
+		t.Errorf("attributes not round-tripped, want: %+v got: %+v", c, got)
+	}
+
+	// Sub-second precision is kept, as it is by the other formats
+	if !got.Time.Equal(eventTime) {
+		t.Errorf("time want: %s got: %s", eventTime.Format(time.RFC3339Nano), got.Time.Format(time.RFC3339Nano))
+	}
+
+	if !sameJSON(t, c.Data, got.Data) {
+		t.Errorf("data want: %s got: %s", c.Data, got.Data)
+	}
+
+	// Avro attributes have no URI or timestamp types, so those are strings
+	want := map[string]interface{}{
+		"boolext":   true,
+		"falseext":  false,
+		"intext":    int32(-42),
+		"minext":    int32(math.MinInt32),
+		"maxext":    int32(math.MaxInt32),
+		"stringext": "value",
+		"bytesext":  []byte{0, 1, 2, 0xff},
+		"uriext":    "https://example.com/x?y=z",
+		"timeext":   "2020-01-02T03:04:05.000000006Z",
+	}
+	if !reflect.DeepEqual(got.Extensions, want) {
+		t.Errorf("extensions want: %#v got: %#v", want, got.Extensions)
+	}
+}
+
+func Test_Avro_Data(t *testing.T) {
+
+	// JSON null is written as the null branch, the same as no data
+	b, err := marshalAvro(&CloudEvent{
+		Type:            "com.example.someevent",
+		SpecVersion:     specVersion10,
+		Source:          "/mycontext",
+		ID:              "1",
+		DataContentType: "application/json",
+		Data:            []byte(`null`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := unmarshalAvro(b); err != nil || len(got.Data) > 0 {
+		t.Errorf("null data want: no data got: %q %v", got.Data, err)
+	}
+
+	var tests = []struct {
+		title       string
+		contentType string
+		data        []byte
+	}{
+		{"No data", "", nil},
+		{"JSON boolean", "application/json", []byte(`true`)},
+		{"JSON number", "application/json", []byte(`-12.5`)},
+		{"JSON string", "application/json", []byte(`"cat"`)},
+		{"JSON object", "application/json", []byte(`{"a":{"b":{"c":false}}}`)},
+		{"JSON array, written as bytes", "application/json", []byte(`["cat","dog"]`)},
+		{"Binary", "application/octet-stream", []byte{0xde, 0xad, 0xbe, 0xef, 0x00}},
+		{"Text", "text/plain", []byte("hello, world")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+
+			b, err := marshalAvro(&CloudEvent{
+				Type:            "com.example.someevent",
+				SpecVersion:     specVersion10,
+				Source:          "/mycontext",
+				ID:              "1",
+				DataContentType: test.contentType,
+				Data:            test.data,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := unmarshalAvro(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(test.data) > 0 && test.contentType == "application/json" {
+				if !sameJSON(t, test.data, got.Data) {
+					t.Errorf("data want: %s got: %s", test.data, got.Data)
+				}
+			} else if !bytes.Equal(got.Data, test.data) {
+				t.Errorf("data want: %x got: %x", test.data, got.Data)
+			}
+		})
+	}
+}
+
+// avroVectors were encoded by github.com/linkedin/goavro from the spec's Avro
+// schema.  goavro writes map entries in no particular order, so these are
+// only decoded rather than compared with what marshalAvro writes.
+var avroVectors = []struct {
+	title   string
+	encoded string
+	want    *CloudEvent
+	data    string
+}{
+	{
+		title:   "Nested data record",
+		encoded: "02046964060c6176726f2d310006020c6e65737465640402026e06000000000000f83f0000",
+		want:    &CloudEvent{ID: "avro-1"},
+		data:    `{"nested":{"n":1.5}}`,
+	},
+	{
+		title:   "Every attribute and data value type",
+		encoded: "14046964060c6176726f2d320c736f7572636506182f6176726f2f736f75726365167370656376657273696f6e0606312e3008747970650620636f6d2e6578616d706c652e6176726f0c696e7465787404531062797465736578740808000102ff0e6e756c6c657874000874696d65063c323031382d30342d30355431373a33313a30302e3132333435363738395a1e64617461636f6e74656e747479706506206170706c69636174696f6e2f6a736f6e0e626f6f6c6578740201000608086e6f6e65000c6e65737465640402026e06000000000000f83f0008776f72640806636174046f6b020100",
+		want: &CloudEvent{
+			Type:            "com.example.avro",
+			SpecVersion:     specVersion10,
+			Source:          "/avro/source",
+			ID:              "avro-2",
+			Time:            time.Date(2018, 4, 5, 17, 31, 0, 123456789, time.UTC),
+			DataContentType: "application/json",
+			Extensions: map[string]interface{}{
+				"boolext":  true,
+				"intext":   int32(-42),
+				"bytesext": []byte{0, 1, 2, 0xff},
+			},
+			rawTime: "2018-04-05T17:31:00.123456789Z",
+		},
+		data: `{"word":"cat","ok":true,"none":null,"nested":{"n":1.5}}`,
+	},
+}
+
+func Test_Avro_ReferenceEncodings(t *testing.T) {
+
+	for _, test := range avroVectors {
+		t.Run(test.title, func(t *testing.T) {
+
+			encoded, err := hex.DecodeString(test.encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := unmarshalAvro(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !sameJSON(t, []byte(test.data), got.Data) {
+				t.Errorf("data want: %s got: %s", test.data, got.Data)
+			}
+			got.Data = nil
+
+			if !got.Time.Equal(test.want.Time) {
+				t.Errorf("time want: %s got: %s", test.want.Time, got.Time)
+			}
+			got.Time = test.want.Time
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("want: %#v got: %#v", test.want, got)
+			}
+		})
+	}
+}
+
+// nestedAvroData returns an event whose data is depth maps nested one inside
+// the next
+func nestedAvroData(depth int) []byte {
+
+	b := []byte{0x00, 0x06}
+	for i := 1; i < depth; i++ {
+		// A single entry, keyed "", holding a CloudEventData record
+		b = append(b, 0x02, 0x00, 0x04)
+	}
+	return append(b, make([]byte, depth)...)
+}
+
+func Test_Avro_MalformedInput(t *testing.T) {
+
+	valid, err := marshalAvro(&CloudEvent{
+		Type:        "com.example.someevent",
+		SpecVersion: specVersion10,
+		Source:      "/mycontext",
+		ID:          "1",
+		Time:        time.Now(),
+		Extensions:  map[string]interface{}{"ext": int32(1)},
+		Data:        []byte(`{"word":"cat","nested":{"n":1}}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The record has no optional parts, so any truncation cuts it short
+	for i := 0; i < len(valid); i++ {
+		if _, err := unmarshalAvro(valid[:i]); err == nil {
+			t.Errorf("truncated to %d of %d bytes: want an error", i, len(valid))
+		}
+	}
+
+	// Nor may garbage panic
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		garbage := make([]byte, rng.Intn(64))
+		rng.Read(garbage)
+		unmarshalAvro(garbage)
+	}
+
+	var tests = []struct {
+		title string
+		input []byte
+	}{
+		{"Empty", nil},
+		{"Unterminated long", []byte{0xff}},
+		{"Negative length", []byte{0x02, 0x01}},
+		{"Length beyond the input", []byte{0x02, 0x0a, 'a'}},
+		{"Invalid attribute branch", []byte{0x02, 0x02, 'a', 0x0a}},
+		{"Truncated boolean", []byte{0x02, 0x02, 'a', 0x02}},
+		{"Invalid data branch", []byte{0x00, 0x0c}},
+		{"Truncated double", []byte{0x00, 0x08, 0x00, 0x00}},
+		{"Invalid data value branch", []byte{0x00, 0x06, 0x02, 0x00, 0x0a}},
+		{"Unterminated data map", []byte{0x00, 0x06, 0x02, 0x00, 0x00}},
+		{"Data nested too deeply", nestedAvroData(avroMaxDataDepth + 1)},
+		{"Data nested far too deeply", nestedAvroData(4000000)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			if _, err := unmarshalAvro(test.input); err == nil {
+				t.Errorf("want an error decoding %.32x", test.input)
+			}
+		})
+	}
+}
+
+func Test_Avro_NestingLimit(t *testing.T) {
+
+	if _, err := unmarshalAvro(nestedAvroData(avroMaxDataDepth)); err != nil {
+		t.Errorf("data nested %d deep: %s", avroMaxDataDepth, err)
+	}
+
+	_, err := unmarshalAvro(nestedAvroData(avroMaxDataDepth + 1))
+	if err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("data nested %d deep: want nested too deeply, got: %v", avroMaxDataDepth+1, err)
+	}
+}
@@ -0,0 +1,77 @@
+package function
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/openfaas-incubator/go-function-sdk"
+)
+
+const batchMediaType = "application/cloudevents-batch+json"
+
+// isBatch reports whether the request uses the JSON batch format, which carries
+// an array of structured events in a single request
+// https://github.com/cloudevents/spec/blob/v1.0/http-protocol-binding.md#33-batched-content-mode
+func isBatch(mediaType string) bool {
+	return mediaType == batchMediaType
+}
+
+// getBatchCloudEvents returns the undecoded members of a batched request so
+// that each can succeed or fail independently
+func getBatchCloudEvents(req []byte) ([]json.RawMessage, error) {
+	var members []json.RawMessage
+
+	if err := json.Unmarshal(req, &members); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func setBatchCloudEvents(events []*CloudEvent) ([]byte, map[string][]string, error) {
+
+	retBytes, err := json.Marshal(events)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := map[string][]string{
+		"Content-Type": []string{batchMediaType + "; charset=utf-8"},
+	}
+
+	return retBytes, header, nil
+}
+
+// handleBatch runs each event of a batched request through the pipeline for
+// its type independently.  The response batch has an event per request event,
+// in the same order, with an error event standing in for any which failed.
+func handleBatch(req *handler.Request, callbackURL []string) (handler.Response, error) {
+
+	members, err := getBatchCloudEvents(req.Body)
+	if err != nil {
+		return sendProblem(http.StatusBadRequest, err)
+	}
+
+	retEvents := make([]*CloudEvent, len(members))
+
+	for i, member := range members {
+
+		c, err := getStructuredCloudEvent(member)
+		if err != nil {
+			retEvents[i] = initErrorEvent(specVersion10, "", http.StatusBadRequest, err)
+			continue
+		}
+
+		retEvent, statusCode, err := handleEvent(c)
+		if err != nil {
+			retEvents[i] = initErrorEvent(c.SpecVersion, c.ID, statusCode, err)
+			continue
+		}
+
+		retEvents[i] = retEvent
+	}
+
+	bMessage, headerVals, err := setBatchCloudEvents(retEvents)
+
+	return sendResponse(bMessage, headerVals, callbackURL, err)
+}
@@ -0,0 +1,242 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	callbackAllowedSchemesEnvVar = "callbackAllowedSchemes"
+	callbackAllowedHostsEnvVar   = "callbackAllowedHosts"
+	callbackAllowedCIDRsEnvVar   = "callbackAllowedCIDRs"
+	callbackLookupTimeoutEnvVar  = "callbackLookupTimeout"
+)
+
+// blockedCIDRs are the loopback, private, link-local (including cloud
+// metadata), shared and otherwise special purpose ranges callbacks may not be
+// made to unless callbackAllowedCIDRs says otherwise
+var blockedCIDRs = parseCIDRs([]string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"100::/64",
+	"2001:db8::/32",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+})
+
+// callbackPolicy decides which URLs callbacks may be sent to.  Only the
+// allowed schemes are accepted.  When hosts is set the host must match one of
+// its entries, where "*.example.com" matches any subdomain of example.com.
+// When cidrs is set every address the host resolves to must lie within one of
+// them, otherwise no address may lie within blockedCIDRs.  Resolving the host
+// may take at most lookupTimeout.
+type callbackPolicy struct {
+	schemes       []string
+	hosts         []string
+	cidrs         []*net.IPNet
+	lookupTimeout time.Duration
+}
+
+// callbackURLs is the policy applied to X-Callback-Url
+var callbackURLs = newCallbackPolicy()
+
+func newCallbackPolicy() *callbackPolicy {
+
+	return &callbackPolicy{
+		schemes:       envList(callbackAllowedSchemesEnvVar, []string{"http", "https"}),
+		hosts:         envList(callbackAllowedHostsEnvVar, nil),
+		cidrs:         parseCIDRs(envList(callbackAllowedCIDRsEnvVar, nil)),
+		lookupTimeout: envDuration(callbackLookupTimeoutEnvVar, 3*time.Second),
+	}
+}
+
+func parseCIDRs(cidrs []string) []*net.IPNet {
+
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Printf("ignoring invalid CIDR %q: %s", cidr, err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// check returns an error explaining why callbacks may not be sent to rawURL,
+// or nil if they may
+func (p *callbackPolicy) check(rawURL string) error {
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("callback URL %q is invalid: %s", rawURL, err)
+	}
+
+	if err := p.checkURL(u); err != nil {
+		return err
+	}
+
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		return p.checkIP(host, ip)
+	}
+
+	// Resolving happens on the request path, so a slow DNS server mustn't
+	// hold the request up for long
+	ctx, cancel := context.WithTimeout(context.Background(), p.lookupTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("callback URL host %s cannot be resolved: %s", host, err)
+	}
+	for _, addr := range addrs {
+		if err := p.checkIP(host, addr.IP); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkURL checks the scheme and host name of u without resolving the host
+func (p *callbackPolicy) checkURL(u *url.URL) error {
+
+	if !u.IsAbs() || len(u.Hostname()) == 0 {
+		return fmt.Errorf("callback URL %q is not an absolute URL", u)
+	}
+
+	if !containsFold(p.schemes, u.Scheme) {
+		return fmt.Errorf("callback URL scheme %q is not allowed", u.Scheme)
+	}
+
+	if len(p.hosts) > 0 && !matchHost(p.hosts, u.Hostname()) {
+		return fmt.Errorf("callback URL host %s is not allowed", u.Hostname())
+	}
+
+	return nil
+}
+
+// checkIP checks an address host resolves to
+func (p *callbackPolicy) checkIP(host string, ip net.IP) error {
+
+	if len(p.cidrs) > 0 {
+		if !containsIP(p.cidrs, ip) {
+			return fmt.Errorf("callback URL host %s (%s) is not in an allowed range", host, ip)
+		}
+		return nil
+	}
+
+	if containsIP(blockedCIDRs, ip) {
+		return fmt.Errorf("callback URL host %s (%s) is a private or reserved address", host, ip)
+	}
+	return nil
+}
+
+// dialControl checks the address actually being connected to, so a host
+// which resolves differently after check, as in DNS rebinding, is still
+// refused
+func (p *callbackPolicy) dialControl(network string, address string, _ syscall.RawConn) error {
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("callback dial to unresolved address %s", address)
+	}
+	return p.checkIP(host, ip)
+}
+
+// client returns an HTTP client which only connects to addresses the policy
+// allows, and only follows redirects to URLs it allows
+func (p *callbackPolicy) client(timeout time.Duration) *http.Client {
+
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control:   p.dialControl,
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// A proxy would make the connection on our behalf, unchecked
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+			return p.checkURL(req.URL)
+		},
+	}
+}
+
+func containsFold(list []string, s string) bool {
+
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHost reports whether host matches one of patterns, where a pattern
+// of the form "*.example.com" matches any subdomain of example.com
+func matchHost(patterns []string, host string) bool {
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,287 @@
+package function
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_CallbackURL_MatchHost(t *testing.T) {
+
+	patterns := []string{"*.example.com", "callbacks.example.org"}
+
+	var tests = []struct {
+		host string
+		want bool
+	}{
+		{"example.com", false},
+		{"a.example.com", true},
+		{"a.b.example.com", true},
+		{"A.Example.COM", true},
+		{"a.example.com.", true},
+		{"evilexample.com", false},
+		{"example.com.evil.net", false},
+		{"a.example.com.evil.net", false},
+		{"callbacks.example.org", true},
+		{"CALLBACKS.example.org", true},
+		{"x.callbacks.example.org", false},
+		{"example.org", false},
+	}
+
+	for _, test := range tests {
+		if got := matchHost(patterns, test.host); got != test.want {
+			t.Errorf("matchHost(%q) want: %t got: %t", test.host, test.want, got)
+		}
+	}
+}
+
+func Test_CallbackURL_Check(t *testing.T) {
+
+	var tests = []struct {
+		title   string
+		policy  *callbackPolicy
+		url     string
+		refused string
+	}{
+		{
+			title:  "Public address",
+			policy: &callbackPolicy{schemes: []string{"http", "https"}},
+			url:    "https://93.184.216.34/events",
+		},
+		{
+			title:  "Public IPv6 address",
+			policy: &callbackPolicy{schemes: []string{"http", "https"}},
+			url:    "http://[2606:2800:220:1:248:1893:25c8:1946]:8080/events",
+		},
+		{
+			title:   "Relative URL",
+			policy:  &callbackPolicy{schemes: []string{"http", "https"}},
+			url:     "/events",
+			refused: "not an absolute URL",
+		},
+		{
+			title:   "No host",
+			policy:  &callbackPolicy{schemes: []string{"http", "https"}},
+			url:     "http:///events",
+			refused: "not an absolute URL",
+		},
+		{
+			title:   "Scheme not allowed",
+			policy:  &callbackPolicy{schemes: []string{"http", "https"}},
+			url:     "file://93.184.216.34/etc/passwd",
+			refused: "scheme \"file\" is not allowed",
+		},
+		{
+			title:  "Scheme compared regardless of case",
+			policy: &callbackPolicy{schemes: []string{"http", "https"}},
+			url:    "HTTPS://93.184.216.34/events",
+		},
+		{
+			title:   "Host not allowed",
+			policy:  &callbackPolicy{schemes: []string{"https"}, hosts: []string{"*.example.com"}},
+			url:     "https://evilexample.com/events",
+			refused: "host evilexample.com is not allowed",
+		},
+		{
+			title:   "Host not allowed is refused before it's resolved",
+			policy:  &callbackPolicy{schemes: []string{"https"}, hosts: []string{"*.example.com"}},
+			url:     "https://93.184.216.34/events",
+			refused: "host 93.184.216.34 is not allowed",
+		},
+		{
+			title:   "Loopback",
+			policy:  &callbackPolicy{schemes: []string{"http"}},
+			url:     "http://127.0.0.1:8080/",
+			refused: "private or reserved",
+		},
+		{
+			title:   "IPv6 loopback",
+			policy:  &callbackPolicy{schemes: []string{"http"}},
+			url:     "http://[::1]/",
+			refused: "private or reserved",
+		},
+		{
+			title:   "Cloud metadata",
+			policy:  &callbackPolicy{schemes: []string{"http"}},
+			url:     "http://169.254.169.254/latest/meta-data/",
+			refused: "private or reserved",
+		},
+		{
+			title:   "IPv4-mapped cloud metadata",
+			policy:  &callbackPolicy{schemes: []string{"http"}},
+			url:     "http://[::ffff:169.254.169.254]/latest/meta-data/",
+			refused: "private or reserved",
+		},
+		{
+			title:   "IPv4-mapped private address in hex",
+			policy:  &callbackPolicy{schemes: []string{"http"}},
+			url:     "http://[::ffff:a00:1]/",
+			refused: "private or reserved",
+		},
+		{
+			title:   "NAT64 of a private address",
+			policy:  &callbackPolicy{schemes: []string{"http"}},
+			url:     "http://[64:ff9b::a00:1]/",
+			refused: "private or reserved",
+		},
+		{
+			title:   "Private range",
+			policy:  &callbackPolicy{schemes: []string{"http"}},
+			url:     "http://10.1.2.3/",
+			refused: "private or reserved",
+		},
+		{
+			title:   "Shared address space",
+			policy:  &callbackPolicy{schemes: []string{"http"}},
+			url:     "http://100.64.0.1/",
+			refused: "private or reserved",
+		},
+		{
+			title:   "Unique local IPv6",
+			policy:  &callbackPolicy{schemes: []string{"http"}},
+			url:     "http://[fd00::1]/",
+			refused: "private or reserved",
+		},
+		{
+			title:   "Unspecified",
+			policy:  &callbackPolicy{schemes: []string{"http"}},
+			url:     "http://0.0.0.0/",
+			refused: "private or reserved",
+		},
+		{
+			title:  "Allowed range",
+			policy: &callbackPolicy{schemes: []string{"http"}, cidrs: parseCIDRs([]string{"10.0.0.0/8"})},
+			url:    "http://10.1.2.3/",
+		},
+		{
+			title:   "Outside the allowed ranges",
+			policy:  &callbackPolicy{schemes: []string{"http"}, cidrs: parseCIDRs([]string{"10.0.0.0/8"})},
+			url:     "http://93.184.216.34/",
+			refused: "not in an allowed range",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+
+			err := test.policy.check(test.url)
+
+			switch {
+			case len(test.refused) == 0 && err != nil:
+				t.Errorf("want %s allowed, got: %s", test.url, err)
+			case len(test.refused) > 0 && err == nil:
+				t.Errorf("want %s refused", test.url)
+			case len(test.refused) > 0 && !strings.Contains(err.Error(), test.refused):
+				t.Errorf("want %s refused as %s, got: %s", test.url, test.refused, err)
+			}
+		})
+	}
+}
+
+func Test_CallbackURL_DialControl(t *testing.T) {
+
+	p := &callbackPolicy{schemes: []string{"http"}}
+
+	var tests = []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:80", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:80", false},
+		{"169.254.169.254:80", false},
+		{"[::ffff:169.254.169.254]:80", false},
+		{"[fe80::1]:80", false},
+		{"example.com:80", false},
+		{"93.184.216.34", false},
+	}
+
+	for _, test := range tests {
+		err := p.dialControl("tcp", test.address, nil)
+		if test.allowed && err != nil {
+			t.Errorf("dial to %s want: allowed got: %s", test.address, err)
+		}
+		if !test.allowed && err == nil {
+			t.Errorf("dial to %s want: refused", test.address)
+		}
+	}
+}
+
+func Test_CallbackURL_Client(t *testing.T) {
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata":
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		case "/file":
+			http.Redirect(w, r, "ftp://127.0.0.1/etc/passwd", http.StatusFound)
+		case "/elsewhere":
+			_, port, _ := net.SplitHostPort(r.Host)
+			http.Redirect(w, r, "http://127.0.0.2:"+port+"/ok", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer target.Close()
+
+	// The test server listens on loopback, so the policy has to allow it
+	p := &callbackPolicy{
+		schemes: []string{"http"},
+		hosts:   []string{"127.0.0.1"},
+		cidrs:   parseCIDRs([]string{"127.0.0.1/32"}),
+	}
+	client := p.client(5 * time.Second)
+
+	var tests = []struct {
+		title   string
+		path    string
+		refused string
+	}{
+		{"Allowed target", "/ok", ""},
+		{"Redirect to cloud metadata", "/metadata", "not allowed"},
+		{"Redirect to a scheme not allowed", "/file", "scheme \"ftp\" is not allowed"},
+		{"Redirect to a host not allowed", "/elsewhere", "host 127.0.0.2 is not allowed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+
+			res, err := client.Get(target.URL + test.path)
+			if err == nil {
+				res.Body.Close()
+			}
+
+			switch {
+			case len(test.refused) == 0 && err != nil:
+				t.Errorf("want allowed, got: %s", err)
+			case len(test.refused) > 0 && err == nil:
+				t.Errorf("want refused, got: %s", res.Status)
+			case len(test.refused) > 0 && !strings.Contains(err.Error(), test.refused):
+				t.Errorf("want refused as %s, got: %s", test.refused, err)
+			}
+		})
+	}
+
+	// Without a hosts list the redirect passes CheckRedirect, and it's the
+	// dial that refuses the address
+	p.hosts = nil
+	client = p.client(5 * time.Second)
+
+	_, err := client.Get(target.URL + "/metadata")
+	if err == nil || !strings.Contains(err.Error(), "not in an allowed range") {
+		t.Errorf("redirect to cloud metadata want: refused by the dialer got: %v", err)
+	}
+
+	// Nor may the policy's own client reach the test server once loopback
+	// isn't allowed
+	p.cidrs = nil
+	client = p.client(5 * time.Second)
+
+	_, err = client.Get(target.URL + "/ok")
+	if err == nil || !strings.Contains(err.Error(), "private or reserved") {
+		t.Errorf("dial to %s want: refused got: %v", target.URL, err)
+	}
+}
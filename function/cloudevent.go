@@ -2,63 +2,83 @@ package function
 
 import (
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/docker/distribution/uuid"
 	"github.com/mitchellh/mapstructure"
 	"github.com/openfaas-incubator/go-function-sdk"
 )
 
-// CloudEvent v0.1
-// https://github.com/cloudevents/spec/blob/v0.1/json-format.md
+// CloudEvent is the spec version independent form of an event.  SpecVersion
+// records the version it arrived in and so the version it is written out in.
+// https://github.com/cloudevents/spec/blob/v1.0/spec.md
 type CloudEvent struct {
-	Type             string            `json:"type"`
-	EventTypeVersion string            `json:"eventTypeVersion,omitempty"`
-	SpecVersion      string            `json:"specversion"`
-	Source           string            `json:"source"`
-	ID               string            `json:"id"`
-	Time             time.Time         `json:"time,omitempty"`
-	RelatedID        string            `json:"relatedid,omitempty"`
-	ContentType      string            `json:"contenttype,omitempty"`
-	Extensions       map[string]string `json:"extensions,omitempty"`
-	Data             json.RawMessage   `json:"data,omitempty"`
+	Type             string
+	EventTypeVersion string
+	SpecVersion      string
+	Source           string
+	ID               string
+	Time             time.Time
+	Subject          string
+	DataSchema       string
+	RelatedID        string
+	DataContentType  string
+	Extensions       map[string]interface{}
+	Data             []byte
+
+	// rawTime is the time attribute as received, kept so that an
+	// unparseable value can be reported by Validate
+	rawTime string
 }
 
 const (
 	headerPrefix = "ce-"
-	fnSource     = "https://rgee0.o6s.io/cloudevents-interop-demo"
+	// v0.1 binary mode marks extensions with a further prefix
+	binaryExtensionPrefixV01 = "x-"
+	fnSource                 = "https://rgee0.o6s.io/cloudevents-interop-demo"
 )
 
-func initCloudEvent(eType string, data map[string]string, reqID string) *CloudEvent {
+func initCloudEvent(specVersion string, eType string, data interface{}, reqID string) *CloudEvent {
 
-	dataField, err := json.Marshal(&data)
+	dataField, err := json.Marshal(data)
 
 	if err != nil {
 		dataField = nil
 	}
 
 	return &CloudEvent{
-		Type:        eType,
-		SpecVersion: "0.2",
-		Source:      fnSource,
-		ID:          uuid.Generate().String(),
-		RelatedID:   reqID,
-		Time:        time.Now(),
-		ContentType: "application/json",
-		Data:        dataField,
+		Type:            eType,
+		SpecVersion:     specVersion,
+		Source:          fnSource,
+		ID:              uuid.Generate().String(),
+		RelatedID:       reqID,
+		Time:            time.Now(),
+		DataContentType: "application/json",
+		Data:            dataField,
 	}
 }
 
-func getCloudEvent(req *handler.Request, structuredRequest bool) (*CloudEvent, error) {
+func getCloudEvent(req *handler.Request, format *eventFormat) (*CloudEvent, error) {
 
-	if structuredRequest {
-		return getStructuredCloudEvent(req.Body)
+	if format != nil {
+		return format.unmarshal(req.Body)
 	}
 
 	c, err := getBinaryCloudEvent(req.Header)
+	if err != nil {
+		return nil, err
+	}
+
 	c.Data = req.Body
-	return c, err
+	if cType := req.Header["Content-Type"]; len(cType) > 0 {
+		c.DataContentType = cType[0]
+	}
+	return c, nil
 }
 
 // getStructuredCloudEvent returns a pointer to a CloudEvent extracted from the
@@ -76,36 +96,98 @@ func getStructuredCloudEvent(req []byte) (*CloudEvent, error) {
 // getBinaryCloudEvent returns a pointer to a CloudEvent extracted from the
 // binary request submitted to the handler
 func getBinaryCloudEvent(header map[string][]string) (*CloudEvent, error) {
-	c := CloudEvent{}
+	probe := specVersionProbe{}
 
-	var headers = make(map[string]string)
+	var (
+		headers    = make(map[string]string)
+		attributes = make(map[string]string)
+		extensions = make(map[string]interface{})
+		invalid    = &ValidationError{}
+	)
 
 	for headerKey, headerVal := range header {
 
-		if !strings.EqualFold(headerKey[:3], headerPrefix) {
+		headerKey = strings.ToLower(headerKey)
+		if !strings.HasPrefix(headerKey, headerPrefix) || len(headerVal) == 0 {
 			continue
 		}
 
-		headerKey = headerKey[3:]
-		headerKey = strings.Replace(headerKey, "-", "", -1)
+		headerKey = headerKey[len(headerPrefix):]
+
+		// Each attribute has a single value, so a repeated header is ambiguous
+		if len(headerVal) > 1 {
+			invalid.add(headerKey, "header must not be repeated")
+			continue
+		}
 		headers[headerKey] = headerVal[0]
 
 	}
 
-	mapstructure.Decode(headers, &c)
+	mapstructure.Decode(headers, &probe)
 
-	return &c, nil
+	specVersion, err := probe.version()
+	if err != nil {
+		return nil, specVersionViolation(err)
+	}
+
+	if specVersion == specVersion10 {
+		for headerKey, headerVal := range headers {
+			if headers[headerKey], err = decodeHeaderValue(headerVal); err != nil {
+				invalid.add(headerKey, "%s", err)
+			}
+		}
+	}
+
+	if len(invalid.Violations) > 0 {
+		sort.Slice(invalid.Violations, func(i, j int) bool {
+			return invalid.Violations[i].Attribute < invalid.Violations[j].Attribute
+		})
+		return nil, invalid
+	}
+
+	w, err := newWireEvent(specVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	// Headers which aren't context attributes of this spec version are extensions
+	for headerKey, headerVal := range headers {
+
+		attributeName := strings.Replace(headerKey, "-", "", -1)
+		if isContextAttribute(specVersion, attributeName) {
+			attributes[attributeName] = headerVal
+			continue
+		}
+
+		if specVersion == specVersion01 {
+			headerKey = strings.TrimPrefix(headerKey, binaryExtensionPrefixV01)
+		}
+		extensions[headerKey] = headerVal
+	}
+
+	mapstructure.Decode(attributes, w)
+
+	c, err := fromWireEvent(w)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(extensions) > 0 {
+		c.Extensions = extensions
+	}
+
+	return c, nil
 }
 
-func setStructuredCloudEvent(c *CloudEvent) ([]byte, map[string][]string, error) {
+func setStructuredCloudEvent(c *CloudEvent, format *eventFormat) ([]byte, map[string][]string, error) {
 
-	retBytes, err := json.Marshal(c)
+	retBytes, err := format.marshal(c)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	header := map[string][]string{
-		"Content-Type": []string{"application/cloudevents+json; charset=utf-8"},
+		"Content-Type": []string{format.contentType},
 	}
 
 	return retBytes, header, nil
@@ -113,21 +195,68 @@ func setStructuredCloudEvent(c *CloudEvent) ([]byte, map[string][]string, error)
 
 func setBinaryCloudEvent(c *CloudEvent) ([]byte, map[string][]string, error) {
 
-	retBytes, err := json.Marshal(c.Data)
-	if err != nil {
-		return nil, nil, err
+	header := make(map[string][]string)
+
+	if len(c.DataContentType) > 0 {
+		header["Content-Type"] = []string{c.DataContentType}
 	}
 
-	header := map[string][]string{
-		"Content-Type":   []string{"application/json; charset=utf-8"},
-		"ce-type":        []string{c.Type},
-		"ce-specversion": []string{c.SpecVersion},
-		"ce-id":          []string{c.ID},
-		"ce-source":      []string{c.Source},
-		"ce-time":        []string{c.Time.Format(time.RFC3339)},
-		"ce-relatedid":   []string{c.RelatedID},
-		"ce-contenttype": []string{c.ContentType},
+	for k, v := range binaryHeaders(c) {
+		if c.SpecVersion == specVersion10 {
+			v = encodeHeaderValue(v)
+		}
+		header[headerPrefix+k] = []string{v}
 	}
 
-	return retBytes, header, nil
+	return c.Data, header, nil
+}
+
+// encodeHeaderValue percent-encodes the characters of v which may not appear
+// in a ce- header value as is: space, double-quote, percent and anything
+// outside printable ASCII
+// https://github.com/cloudevents/spec/blob/v1.0/http-protocol-binding.md#3132-http-header-values
+func encodeHeaderValue(v string) string {
+
+	var b strings.Builder
+
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c <= ' ' || c > '~' || c == '"' || c == '%':
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// decodeHeaderValue reverses encodeHeaderValue, requiring the result to be
+// valid UTF-8
+func decodeHeaderValue(v string) (string, error) {
+
+	var b strings.Builder
+
+	for i := 0; i < len(v); i++ {
+
+		if v[i] != '%' {
+			b.WriteByte(v[i])
+			continue
+		}
+
+		if i+2 >= len(v) {
+			return "", fmt.Errorf("truncated percent-encoding in header value")
+		}
+
+		decoded, err := strconv.ParseUint(v[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid percent-encoding %q in header value", v[i:i+3])
+		}
+		b.WriteByte(byte(decoded))
+		i += 2
+	}
+
+	if !utf8.ValidString(b.String()) {
+		return "", fmt.Errorf("header value is not valid UTF-8 once percent-decoded")
+	}
+	return b.String(), nil
 }
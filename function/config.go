@@ -0,0 +1,88 @@
+package function
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envInt returns the integer value of the named environment variable, or def
+// when it is unset or invalid
+func envInt(name string, def int) int {
+
+	val := os.Getenv(name)
+	if len(val) == 0 {
+		return def
+	}
+
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		log.Printf("%s: invalid integer %q, using %d", name, val, def)
+		return def
+	}
+	return i
+}
+
+// envIntAtLeast returns the integer value of the named environment variable,
+// or def when it is unset, invalid or less than min
+func envIntAtLeast(name string, def int, min int) int {
+
+	i := envInt(name, def)
+	if i < min {
+		log.Printf("%s: must be at least %d, using %d", name, min, def)
+		return def
+	}
+	return i
+}
+
+// envDuration returns the duration value, such as "1.5s", of the named
+// environment variable, or def when it is unset or invalid
+func envDuration(name string, def time.Duration) time.Duration {
+
+	val := os.Getenv(name)
+	if len(val) == 0 {
+		return def
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Printf("%s: invalid duration %q, using %s", name, val, def)
+		return def
+	}
+	return d
+}
+
+// envString returns the value of the named environment variable, or def when
+// it is unset
+func envString(name string, def string) string {
+
+	if val := os.Getenv(name); len(val) > 0 {
+		return val
+	}
+	return def
+}
+
+// envList returns the comma separated values of the named environment
+// variable, or def when it is unset
+func envList(name string, def []string) []string {
+
+	val := os.Getenv(name)
+	if len(val) == 0 {
+		return def
+	}
+	return splitList(val)
+}
+
+// splitList returns the non-empty values of a comma separated list
+func splitList(val string) []string {
+
+	var list []string
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,78 @@
+package function
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// isJSONContentType reports whether data of the given content type is JSON.
+// Events without a data content type are taken to carry JSON, as the JSON
+// event format implies.
+func isJSONContentType(contentType string) bool {
+
+	if len(contentType) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" ||
+		mediaType == "text/json" ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+// isTextContentType reports whether data of the given content type is text
+func isTextContentType(contentType string) bool {
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/xml" ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		isJSONContentType(contentType)
+}
+
+// jsonData returns the data of c as a JSON value and true when it can be
+// embedded in a JSON event as is, otherwise false
+func jsonData(c *CloudEvent) (json.RawMessage, bool) {
+
+	if len(c.Data) == 0 || !isJSONContentType(c.DataContentType) || !json.Valid(c.Data) {
+		return nil, false
+	}
+	return json.RawMessage(c.Data), true
+}
+
+// stringData returns the data of c as a JSON string, as text when it is valid
+// UTF-8 and otherwise base64 encoded
+func stringData(c *CloudEvent) (json.RawMessage, error) {
+
+	if isTextContentType(c.DataContentType) && utf8.Valid(c.Data) {
+		return json.Marshal(string(c.Data))
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(c.Data))
+}
+
+// decodeJSONData returns the bytes carried by the data member of a JSON event.
+// When the data content type isn't JSON and the member is a string, the data
+// is the string itself rather than its JSON encoding.
+func decodeJSONData(data json.RawMessage, contentType string) []byte {
+
+	if len(data) == 0 || isJSONContentType(contentType) {
+		return data
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return data
+	}
+	return []byte(s)
+}
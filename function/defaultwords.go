@@ -0,0 +1,28 @@
+package function
+
+// embeddedWordLists are the word lists compiled into the function, served by
+// embed://<name> word sources
+var embeddedWordLists = map[string]map[string][]string{
+	"default": {
+		"noun": {
+			"aardvark", "balloon", "castle", "dinosaur", "elbow", "flamingo", "guitar", "helicopter",
+			"igloo", "jellyfish", "kettle", "lighthouse", "mushroom", "noodle", "octopus", "pancake",
+			"quilt", "robot", "sandwich", "teapot", "umbrella", "volcano", "walrus", "yo-yo", "zeppelin",
+		},
+		"verb": {
+			"bounce", "cartwheel", "dance", "explode", "fling", "giggle", "hop", "juggle", "knit",
+			"leap", "mumble", "nibble", "paddle", "quack", "rummage", "skip", "tiptoe", "wobble", "yodel",
+		},
+		"adjective": {
+			"bouncy", "clumsy", "dazzling", "enormous", "fuzzy", "gigantic", "hairy", "itchy", "jolly",
+			"lumpy", "mysterious", "noisy", "purple", "quirky", "slimy", "tiny", "wobbly", "zany",
+		},
+		"adverb": {
+			"awkwardly", "bravely", "cheerfully", "dramatically", "eagerly", "frantically", "gracefully",
+			"loudly", "mysteriously", "nervously", "quickly", "quietly", "sleepily", "wildly",
+		},
+		"exclamation": {
+			"aha", "boing", "crikey", "eek", "gadzooks", "hooray", "kapow", "oops", "whoopee", "yikes",
+		},
+	},
+}
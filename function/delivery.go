@@ -0,0 +1,565 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/uuid"
+)
+
+const (
+	callbackMaxAttemptsEnvVar    = "callbackMaxAttempts"
+	callbackInitialBackoffEnvVar = "callbackInitialBackoff"
+	callbackMaxBackoffEnvVar     = "callbackMaxBackoff"
+	callbackTimeoutEnvVar        = "callbackTimeout"
+	callbackWorkersEnvVar        = "callbackWorkers"
+	callbackQueueSizeEnvVar      = "callbackQueueSize"
+	deadLetterDirEnvVar          = "deadLetterDir"
+	deliveryStatusTTLEnvVar      = "deliveryStatusTTL"
+	pendingDirEnvVar             = "pendingDir"
+)
+
+var (
+	errQueueFull    = errors.New("callback delivery queue is full, try again later")
+	errShuttingDown = errors.New("shutting down, try again later")
+)
+
+// retryPolicy controls how many times, and how patiently, a callback is
+// attempted before it is given up on
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+func init() {
+
+	// Backoff jitter is the only use of the global source, words are picked
+	// with requestRand
+	rand.Seed(time.Now().UTC().UnixNano())
+
+}
+
+// backoff returns how long to wait after the given number of failed attempts,
+// growing exponentially up to maxBackoff with full jitter so that callbacks
+// failing together don't retry together
+func (p retryPolicy) backoff(attempts int) time.Duration {
+
+	ceiling := p.maxBackoff
+	if attempts < 32 {
+		if d := p.initialBackoff << uint(attempts-1); d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+	deliveryPartial   = "partial"
+)
+
+// deliveryStatus is what is known about the progress of a delivery
+type deliveryStatus struct {
+	ID          string     `json:"id"`
+	URL         string     `json:"url"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	LastStatus  int        `json:"lastStatus,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	Created     time.Time  `json:"created"`
+	LastAttempt *time.Time `json:"lastAttempt,omitempty"`
+	Completed   *time.Time `json:"completed,omitempty"`
+}
+
+// delivery is a response event on its way to a callback URL.  Trusted
+// deliveries go to URLs set by the operator rather than a request, so they are
+// neither restricted by the callback URL policy nor asked for permission.
+type delivery struct {
+	deliveryStatus
+	Header  map[string][]string `json:"header"`
+	Body    []byte              `json:"body"`
+	Trusted bool                `json:"trusted,omitempty"`
+}
+
+func newDelivery(callbackURL string, bMessage []byte, headerVals map[string][]string) *delivery {
+
+	return &delivery{
+		deliveryStatus: deliveryStatus{
+			ID:      uuid.Generate().String(),
+			URL:     callbackURL,
+			Status:  deliveryPending,
+			Created: time.Now().UTC(),
+		},
+		Header: headerVals,
+		Body:   bMessage,
+	}
+}
+
+// deliveryReport is the status of the deliveries of a response event to
+// each of its callback URLs.  Status is pending until every delivery has
+// completed, then delivered or failed if they all were, otherwise partial.
+type deliveryReport struct {
+	ID         string           `json:"id"`
+	Status     string           `json:"status"`
+	Deliveries []deliveryStatus `json:"deliveries"`
+}
+
+// newDeliveryReport returns the report on the deliveries dls
+func newDeliveryReport(id string, dls []*delivery) deliveryReport {
+
+	r := deliveryReport{ID: id}
+	counts := make(map[string]int)
+
+	for _, dl := range dls {
+		r.Deliveries = append(r.Deliveries, dl.deliveryStatus)
+		counts[dl.Status]++
+	}
+
+	switch {
+	case counts[deliveryPending] > 0:
+		r.Status = deliveryPending
+	case counts[deliveryFailed] == 0:
+		r.Status = deliveryDelivered
+	case counts[deliveryDelivered] == 0:
+		r.Status = deliveryFailed
+	default:
+		r.Status = deliveryPartial
+	}
+
+	return r
+}
+
+// dispatcher delivers callbacks from a bounded queue using a fixed pool of
+// workers, retrying according to its policy and writing any delivery which
+// exhausts its retries to the dead-letter directory.  Deliveries waiting to
+// be retried don't hold a worker, coming back to them through retries when
+// due.  The status of each
+// delivery, and of each group of deliveries of the same event, is kept for
+// statusTTL after it completes.  Each attempt is signed
+// with the current key in signer, and made only once the target has given
+// permission through webhooks, unless it is trusted and sent with
+// trustedClient instead.  Deliveries yet to complete are kept in pendingDir
+// so that they survive a restart.
+type dispatcher struct {
+	policy        retryPolicy
+	client        *http.Client
+	trustedClient *http.Client
+	workers       int
+	queue         chan *delivery
+	retries       chan *delivery
+	deadLetterDir string
+	pendingDir    string
+	statusTTL     time.Duration
+	signer        *keyRing
+	webhooks      *webhookValidator
+	start         sync.Once
+	inflight      sync.WaitGroup
+	stopping      chan struct{}
+	stop          sync.Once
+
+	mu         sync.Mutex
+	draining   bool
+	deliveries map[string]*delivery
+	reports    map[string][]*delivery
+}
+
+// callbacks is the dispatcher for X-Callback-Url responses
+var callbacks = newDispatcher()
+
+func newDispatcher() *dispatcher {
+
+	timeout := envDuration(callbackTimeoutEnvVar, 10*time.Second)
+
+	return &dispatcher{
+		policy: retryPolicy{
+			maxAttempts:    envInt(callbackMaxAttemptsEnvVar, 5),
+			initialBackoff: envDuration(callbackInitialBackoffEnvVar, 500*time.Millisecond),
+			maxBackoff:     envDuration(callbackMaxBackoffEnvVar, 30*time.Second),
+		},
+		client:        callbackURLs.client(timeout),
+		trustedClient: &http.Client{Timeout: timeout},
+		workers:       envIntAtLeast(callbackWorkersEnvVar, 4, 1),
+		queue:         make(chan *delivery, envIntAtLeast(callbackQueueSizeEnvVar, 100, 1)),
+		deadLetterDir: envString(deadLetterDirEnvVar, filepath.Join(os.TempDir(), "cloudevents-interop-demo", "dead-letter")),
+		pendingDir:    envString(pendingDirEnvVar, filepath.Join(os.TempDir(), "cloudevents-interop-demo", "pending")),
+		statusTTL:     envDuration(deliveryStatusTTLEnvVar, time.Hour),
+		signer:        newKeyRing(envList(callbackSigningKeysEnvVar, nil), envString(callbackSigningKeysFileEnvVar, "")),
+		webhooks:      newWebhookValidator(),
+		retries:       make(chan *delivery),
+		stopping:      make(chan struct{}),
+		deliveries:    make(map[string]*delivery),
+		reports:       make(map[string][]*delivery),
+	}
+}
+
+// enqueue queues each of dls for delivery and independently of the others,
+// returning the report on them as a group.  Either all are queued or, rather
+// than blocking when the queue is too full or while shutting down, none are.
+func (d *dispatcher) enqueue(dls []*delivery) (deliveryReport, error) {
+
+	d.start.Do(func() {
+		for i := 0; i < d.workers; i++ {
+			go d.work()
+		}
+	})
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.draining {
+		return deliveryReport{}, errShuttingDown
+	}
+
+	d.expire()
+
+	// Workers only take from the queue, so while d.mu is held there is at
+	// least this much room
+	if cap(d.queue)-len(d.queue) < len(dls) {
+		return deliveryReport{}, errQueueFull
+	}
+
+	id := uuid.Generate().String()
+	report := newDeliveryReport(id, dls)
+
+	d.reports[id] = dls
+	for _, dl := range dls {
+		d.persist(dl)
+		d.deliveries[dl.ID] = dl
+		d.inflight.Add(1)
+		d.queue <- dl
+	}
+
+	return report, nil
+}
+
+// status returns a snapshot of the status of the delivery with the given ID
+func (d *dispatcher) status(id string) (deliveryStatus, bool) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.expire()
+
+	dl, ok := d.deliveries[id]
+	if !ok {
+		return deliveryStatus{}, false
+	}
+	return dl.deliveryStatus, true
+}
+
+// report returns a snapshot of the report on the group of deliveries with
+// the given ID
+func (d *dispatcher) report(id string) (deliveryReport, bool) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.expire()
+
+	dls, ok := d.reports[id]
+	if !ok {
+		return deliveryReport{}, false
+	}
+	return newDeliveryReport(id, dls), true
+}
+
+// expire forgets deliveries which completed more than statusTTL ago, and
+// groups once all of their deliveries are forgotten.  The caller must hold
+// d.mu.
+func (d *dispatcher) expire() {
+
+	cutoff := time.Now().Add(-d.statusTTL)
+	for id, dl := range d.deliveries {
+		if dl.Completed != nil && dl.Completed.Before(cutoff) {
+			delete(d.deliveries, id)
+		}
+	}
+
+	for id, dls := range d.reports {
+		expired := true
+		for _, dl := range dls {
+			if _, ok := d.deliveries[dl.ID]; ok {
+				expired = false
+				break
+			}
+		}
+		if expired {
+			delete(d.reports, id)
+		}
+	}
+}
+
+func (d *dispatcher) work() {
+
+	for {
+		var dl *delivery
+		select {
+		case dl = <-d.queue:
+		case dl = <-d.retries:
+		}
+
+		// Once stopping, queued deliveries are left in pendingDir
+		if d.stopped() || d.deliver(dl) {
+			d.inflight.Done()
+		}
+	}
+}
+
+// attemptError describes a failed delivery attempt and whether it is worth
+// retrying, after at least retryAfter if the receiver asked for a delay.  A
+// deferred attempt wasn't made at all, as the target's allowed rate means it
+// must wait retryAfter first, so doesn't count as one.
+type attemptError struct {
+	msg        string
+	retry      bool
+	retryAfter time.Duration
+	deferred   bool
+}
+
+func (e *attemptError) Error() string {
+	return e.msg
+}
+
+// deliver makes the next attempt at dl, reporting whether it is done with.
+// A delivery to be retried, or held back by the target's allowed rate, isn't:
+// it is parked until it is due rather than holding the worker meanwhile.  One
+// which fails permanently or runs out of attempts is dead-lettered.
+func (d *dispatcher) deliver(dl *delivery) bool {
+
+	statusCode, err := d.attempt(dl)
+
+	switch {
+	case err != nil && err.deferred:
+		d.park(dl, err.retryAfter)
+		return false
+	case err == nil:
+		d.record(dl, statusCode, nil, deliveryDelivered)
+		d.unpersist(dl)
+		return true
+	case !err.retry || dl.Attempts+1 >= d.policy.maxAttempts:
+		d.record(dl, statusCode, err, deliveryFailed)
+		log.Printf("callback %s to %s failed after %d attempt(s): %s", dl.ID, dl.URL, dl.Attempts, err)
+		d.deadLetter(dl)
+		d.unpersist(dl)
+		return true
+	}
+
+	d.record(dl, statusCode, err, deliveryPending)
+	d.persist(dl)
+
+	// The target's Retry-After is honoured only up to maxBackoff, as it is
+	// named by the request and mustn't keep a delivery from being retried
+	wait := d.policy.backoff(dl.Attempts)
+	if err.retryAfter > wait {
+		wait = err.retryAfter
+	}
+	if wait > d.policy.maxBackoff {
+		wait = d.policy.maxBackoff
+	}
+
+	d.park(dl, wait)
+	return false
+}
+
+// park requeues dl for the workers once wait has passed.  When shutting down
+// it gives up waiting, leaving dl in pendingDir to be resumed on next start.
+func (d *dispatcher) park(dl *delivery, wait time.Duration) {
+
+	go func() {
+		if d.sleep(wait) {
+			select {
+			case d.retries <- dl:
+				return
+			case <-d.stopping:
+			}
+		}
+		d.inflight.Done()
+	}()
+}
+
+// sleep waits for duration, returning false if it was cut short by shutdown
+func (d *dispatcher) sleep(duration time.Duration) bool {
+
+	if duration <= 0 {
+		return !d.stopped()
+	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-d.stopping:
+		return false
+	}
+}
+
+// stopped reports whether the dispatcher is shutting down
+func (d *dispatcher) stopped() bool {
+
+	select {
+	case <-d.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// record updates the status of dl with the outcome of an attempt
+func (d *dispatcher) record(dl *delivery, statusCode int, err *attemptError, status string) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	dl.Attempts++
+	dl.LastAttempt = &now
+	dl.LastStatus = statusCode
+	dl.LastError = ""
+	if err != nil {
+		dl.LastError = err.msg
+	}
+
+	dl.Status = status
+	if status != deliveryPending {
+		dl.Completed = &now
+	}
+}
+
+// attempt makes a single delivery attempt, returning the status code of the
+// response if there was one.  Transport failures, 429 and 5xx responses are
+// worth retrying, anything else other than a 2xx is not.
+func (d *dispatcher) attempt(dl *delivery) (int, *attemptError) {
+
+	client := d.trustedClient
+	if !dl.Trusted {
+		client = d.client
+
+		wait, aErr := d.webhooks.permit(client, dl.URL)
+		if aErr != nil {
+			return 0, aErr
+		}
+		if wait > 0 {
+			return 0, &attemptError{msg: "waiting for the target's allowed rate", retryAfter: wait, deferred: true}
+		}
+	}
+
+	postBack, err := http.NewRequest(http.MethodPost, dl.URL, bytes.NewReader(dl.Body))
+	if err != nil {
+		return 0, &attemptError{msg: err.Error()}
+	}
+
+	for k, v := range dl.Header {
+		for _, val := range v {
+			postBack.Header.Add(k, val)
+		}
+	}
+
+	// Signed afresh each attempt so the timestamp stays current
+	if err := d.signer.sign(postBack.Header, dl.Body); err != nil {
+		return 0, &attemptError{msg: "signing callback: " + err.Error(), retry: true}
+	}
+
+	res, err := client.Do(postBack)
+	if err != nil {
+		return 0, &attemptError{msg: err.Error(), retry: true}
+	}
+
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return res.StatusCode, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return res.StatusCode, &attemptError{
+			msg:        res.Status,
+			retry:      true,
+			retryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
+	}
+
+	return res.StatusCode, &attemptError{msg: res.Status}
+}
+
+// deadLetter writes dl to the dead-letter directory so it can be inspected
+// and replayed by hand
+func (d *dispatcher) deadLetter(dl *delivery) {
+
+	if err := writeDelivery(d.deadLetterDir, dl); err != nil {
+		log.Printf("callback %s: unable to write dead-letter: %s", dl.ID, err)
+	}
+}
+
+// writeDelivery writes dl as JSON to <id>.json in dir, replacing any earlier
+// copy in one step so a reader never sees part of it
+func writeDelivery(dir string, dl *delivery) error {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	record, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(record)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, dl.ID+".json"))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// parseRetryAfter returns the delay requested by a Retry-After header given
+// either in seconds or as an HTTP date
+// https://tools.ietf.org/html/rfc7231#section-7.1.3
+func parseRetryAfter(retryAfter string) time.Duration {
+
+	if len(retryAfter) == 0 {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(retryAfter); err == nil {
+		if wait := time.Until(t); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
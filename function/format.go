@@ -0,0 +1,52 @@
+package function
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const jsonMediaType = "application/cloudevents+json"
+
+// eventFormat encodes and decodes structured mode events for a media type
+// https://github.com/cloudevents/spec/blob/v1.0/spec.md#event-format
+type eventFormat struct {
+	mediaType   string
+	contentType string
+	marshal     func(c *CloudEvent) ([]byte, error)
+	unmarshal   func(b []byte) (*CloudEvent, error)
+}
+
+// eventFormats is the registry of structured mode formats keyed by media type,
+// consulted both when decoding requests and encoding responses
+var eventFormats = make(map[string]*eventFormat)
+
+func registerEventFormat(f *eventFormat) {
+	eventFormats[f.mediaType] = f
+}
+
+func init() {
+
+	registerEventFormat(&eventFormat{
+		mediaType:   jsonMediaType,
+		contentType: jsonMediaType + "; charset=utf-8",
+		marshal:     func(c *CloudEvent) ([]byte, error) { return c.MarshalJSON() },
+		unmarshal:   getStructuredCloudEvent,
+	})
+
+}
+
+// getEventFormat returns the format of a structured mode request, or nil when
+// the request is in binary mode.  An application/cloudevents media type with
+// no registered format is reported with a 415 status code.
+func getEventFormat(mediaType string) (*eventFormat, int, error) {
+
+	if !isStructured(mediaType) {
+		return nil, http.StatusOK, nil
+	}
+
+	if f, ok := eventFormats[mediaType]; ok {
+		return f, http.StatusOK, nil
+	}
+
+	return nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported event format: %s", mediaType)
+}
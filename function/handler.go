@@ -1,29 +1,21 @@
 package function
 
 import (
-	"bytes"
-	"log"
 	"math/rand"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/openfaas-incubator/go-function-sdk"
 )
 
 const (
-	structuredContentType = "cloudevents"
-	wordsURLEnvVar        = "wordsURL"
-	reqEventTypePattern   = "found"
-	resEventTypePattern   = "picked"
+	structuredMediaType = "application/cloudevents"
+	wordsURLEnvVar      = "wordsURL"
 )
 
-var wordList = make(map[string][]string)
-
 func init() {
 
-	wordList = getWordList()
-	rand.Seed(time.Now().UTC().UnixNano())
+	words.load()
 
 }
 
@@ -31,84 +23,151 @@ func init() {
 // If the value is prefixed with the CloudEvents media type application/cloudevents, indicating the use of a known
 // event format, the receiver uses structured mode, otherwise it defaults to binary mode.
 // https://github.com/cloudevents/spec/blob/a12b6b618916c89bfa5595fc76732f07f89219b5/http-transport-binding.md#3-http-message-mapping
-func isStructured(httpContentTypes []string) bool {
-
-	for _, cType := range httpContentTypes {
-		if strings.Contains(cType, structuredContentType) {
-			return true
-		}
-	}
-	return false
-}
+func isStructured(mediaType string) bool {
 
-func extractCallbackURL(req *handler.Request) []string {
-
-	if cbVal, ok := req.Header["X-Callback-Url"]; ok {
-		return cbVal
-	}
-	return nil
+	return mediaType == structuredMediaType ||
+		strings.HasPrefix(mediaType, structuredMediaType+"+") ||
+		strings.HasPrefix(mediaType, structuredMediaType+"-")
 }
 
-func extractWordType(requestedType string) string {
-
-	splitType := strings.Split(requestedType, ".")
-	if len(splitType) == 3 {
-		return splitType[2]
-	}
-	return "noun"
-
-}
-
-func makeAsyncCall(callbackURL string, bMessage []byte, headerVals map[string][]string) {
-
-	postBack, _ := http.NewRequest(http.MethodPost, callbackURL, bytes.NewBuffer(bMessage))
-	client := &http.Client{}
-	for k, v := range headerVals {
-		postBack.Header.Set(k, strings.Join(v, ","))
-	}
-	res, resErr := client.Do(postBack)
-	if resErr != nil {
-		log.Println(resErr)
+// extractCallbackURL returns each URL listed in the X-Callback-Url headers of
+// the request, which may also hold comma separated lists, or an error saying
+// why callbacks may not be sent to one of them
+func extractCallbackURL(req *handler.Request) ([]string, error) {
+
+	var callbackURL []string
+	seen := make(map[string]bool)
+
+	for _, cbVal := range req.Header["X-Callback-Url"] {
+		for _, u := range strings.Split(cbVal, ",") {
+			if u = strings.TrimSpace(u); len(u) == 0 || seen[u] {
+				continue
+			}
+			if err := callbackURLs.check(u); err != nil {
+				return nil, err
+			}
+			seen[u] = true
+			callbackURL = append(callbackURL, u)
+		}
 	}
 
-	defer res.Body.Close()
-
+	return callbackURL, nil
 }
 
 // sendCloudEvent - take an existing cloud event struct and generate the handler response for it according to
 // the demo conventions.  Respond to requests with the respective event type (binary/structured).
 // If X-Callback-URL is set then send only a 202 to the client with the response event sent to X-Callback-URL
-func sendCloudEvent(c *CloudEvent, structuredRequest bool, callbackURL []string, err error) (handler.Response, error) {
+func sendCloudEvent(c *CloudEvent, format *eventFormat, callbackURL []string, err error) (handler.Response, error) {
 
 	var (
 		bMessage   []byte
 		headerVals map[string][]string
-		statusCode = http.StatusOK
 	)
 
 	if err != nil {
 		return handler.Response{}, err
 	}
 
-	if structuredRequest {
-		bMessage, headerVals, err = setStructuredCloudEvent(c)
+	if format != nil {
+		bMessage, headerVals, err = setStructuredCloudEvent(c, format)
 	} else {
 		bMessage, headerVals, err = setBinaryCloudEvent(c)
 	}
 
+	return sendResponse(bMessage, headerVals, callbackURL, err)
+}
+
+// sendResponse returns the encoded response event(s) to the client, or when
+// callback URLs are given sends them to each and returns a 202 to the client
+// carrying the report on those deliveries
+func sendResponse(bMessage []byte, headerVals map[string][]string, callbackURL []string, err error) (handler.Response, error) {
+
+	if err != nil {
+		return handler.Response{}, err
+	}
+
 	//Async request?
 	if len(callbackURL) > 0 {
 
-		go makeAsyncCall(callbackURL[0], bMessage, headerVals)
-		bMessage, headerVals, statusCode = nil, nil, http.StatusAccepted
+		dls := make([]*delivery, 0, len(callbackURL))
+		for _, u := range callbackURL {
+			dls = append(dls, newDelivery(u, bMessage, headerVals))
+		}
+
+		report, err := callbacks.enqueue(dls)
+		if err != nil {
+			return sendProblem(http.StatusServiceUnavailable, err)
+		}
+		return sendDeliveryStatus(http.StatusAccepted, report.ID, report)
 
 	}
 
 	return handler.Response{
 		Body:       bMessage,
-		StatusCode: statusCode,
+		StatusCode: http.StatusOK,
 		Header:     headerVals,
-	}, err
+	}, nil
+}
+
+// handleEvent runs a single request event through the action its type is
+// routed to, returning either the response event or the status code and
+// error saying why there isn't one
+func handleEvent(c *CloudEvent) (*CloudEvent, int, error) {
+
+	if err := c.Validate(); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	m, err := routeEvent(c)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+
+	wordList, err := words.get()
+	if err != nil {
+		return nil, http.StatusServiceUnavailable, err
+	}
+
+	dataVal, statusCode, err := m.route.action(c, wordList, m, requestRand(c))
+	if err != nil {
+		return nil, statusCode, err
+	}
+
+	specVersion, err := getTargetSpecVersion(c.SpecVersion)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	retEvent := initCloudEvent(c.SpecVersion, m.responseType(), dataVal, c.ID)
+
+	// Carry extensions such as tracing context over from the request
+	retEvent.Extensions = withoutExtension(c.Extensions, relatedIDExtension)
+
+	retEvent, err = translateCloudEvent(retEvent, specVersion)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return retEvent, http.StatusOK, nil
+}
+
+// pickWord picks the words c asks for, of the word type of its route, from
+// wordList, returning the data of the response or the status code and error
+// saying why there are none
+func pickWord(c *CloudEvent, wordList WordList, m *routeMatch, rng *rand.Rand) (interface{}, int, error) {
+
+	selection, err := extractWordSelection(c)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	wordType := m.wordType()
+	dataVal, err := getWordValue(wordList[wordType], wordType, selection, rng)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+
+	return dataVal, http.StatusOK, nil
 }
 
 // Handle a function invocation
@@ -120,23 +179,52 @@ func Handle(req handler.Request) (handler.Response, error) {
 		callbackURL []string
 	)
 
-	if len(wordList) == 0 {
-		wordList = getWordList()
+	switch req.Method {
+	case http.MethodGet:
+		// Callback delivery status
+		return handleDeliveryStatus(&req)
+	case http.MethodOptions:
+		return handleWebhookValidation(&req)
+	}
+
+	callbackURL, err = extractCallbackURL(&req)
+	if err != nil {
+		return sendProblem(http.StatusBadRequest, err)
+	}
+
+	mediaType, params, err := parseContentType(req.Header["Content-Type"])
+	if err != nil {
+		return sendProblem(http.StatusBadRequest, err)
+	}
+
+	if isStructured(mediaType) {
+		if req.Body, err = decodeCharset(req.Body, params["charset"]); err != nil {
+			return sendProblem(http.StatusUnsupportedMediaType, err)
+		}
 	}
 
-	structuredRequest := isStructured(req.Header["Content-Type"])
-	callbackURL = extractCallbackURL(&req)
+	if isBatch(mediaType) {
+		return handleBatch(&req, callbackURL)
+	}
 
-	c, err = getCloudEvent(&req, structuredRequest)
+	format, statusCode, err := getEventFormat(mediaType)
+	if err != nil {
+		return sendProblem(statusCode, err)
+	}
 
-	wordType := extractWordType(c.Type)
-	dataVal := getWordValue(wordList[wordType])
+	c, err = getCloudEvent(&req, format)
+	if err != nil {
+		return sendProblem(http.StatusBadRequest, err)
+	}
 
-	if dataVal != nil {
-		retEventType := strings.Replace(c.Type, reqEventTypePattern, resEventTypePattern, -1)
-		retEvent = initCloudEvent(retEventType, dataVal, c.ID)
+	retEvent, statusCode, err = handleEvent(c)
+	if _, noRoute := err.(*noRouteError); noRoute || statusCode == http.StatusServiceUnavailable {
+		return sendErrorEvent(c, format, statusCode, err)
+	}
+	if err != nil {
+		return sendProblem(statusCode, err)
 	}
 
-	return sendCloudEvent(retEvent, structuredRequest, callbackURL, err)
+	return sendCloudEvent(retEvent, format, callbackURL, err)
 
 }
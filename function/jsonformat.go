@@ -0,0 +1,230 @@
+package function
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// extensionsMember is the v0.1 bag of extension attributes.  Earlier releases
+// of this function nested extensions there for every spec version, so it is
+// still read whatever the version.
+const extensionsMember = "extensions"
+
+// MarshalJSON writes c in the JSON event format of its spec version.  From
+// v0.2 onwards extension attributes are written as top level members.
+// https://github.com/cloudevents/spec/blob/v1.0/json-format.md#2-attributes
+func (c *CloudEvent) MarshalJSON() ([]byte, error) {
+
+	w, err := toWireEvent(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if e, ok := w.(*cloudEventV01); ok {
+		e.Extensions = jsonExtensions(e.Extensions)
+		return json.Marshal(e)
+	}
+
+	retBytes, err := json.Marshal(w)
+	if err != nil || len(c.Extensions) == 0 {
+		return retBytes, err
+	}
+
+	members := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(retBytes, &members); err != nil {
+		return nil, err
+	}
+
+	for name, v := range jsonExtensions(c.Extensions) {
+
+		// Context attributes take precedence over a clashing extension
+		if _, ok := members[name]; ok {
+			continue
+		}
+
+		member, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		members[name] = member
+	}
+
+	return json.Marshal(members)
+}
+
+// UnmarshalJSON reads c from the JSON event format of whichever spec version
+// it declares.  Members which aren't context attributes of that version are
+// read into Extensions, keeping their JSON type.
+func (c *CloudEvent) UnmarshalJSON(b []byte) error {
+	probe := specVersionProbe{}
+
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return err
+	}
+
+	specVersion, err := probe.version()
+	if err != nil {
+		return specVersionViolation(err)
+	}
+
+	w, err := newWireEvent(specVersion)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(b, w); err != nil {
+		return err
+	}
+
+	decoded, err := fromWireEvent(w)
+	if err != nil {
+		return err
+	}
+
+	members := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &members); err != nil {
+		return err
+	}
+
+	known := jsonMembers(w)
+	extensions := make(map[string]interface{})
+
+	for name, member := range members {
+
+		if name == extensionsMember {
+			bag := make(map[string]json.RawMessage)
+			if err := json.Unmarshal(member, &bag); err != nil {
+				return fmt.Errorf("%s must be an object: %s", extensionsMember, err)
+			}
+			for k, v := range bag {
+				if !isJSONNull(v) {
+					extensions[k] = decodeExtension(v)
+				}
+			}
+			continue
+		}
+
+		// The JSON format takes a null attribute to be unset
+		if !known[name] && !isJSONNull(member) {
+			extensions[name] = decodeExtension(member)
+		}
+	}
+
+	decoded.Extensions = withoutExtension(extensions, relatedIDExtension)
+	if len(decoded.RelatedID) == 0 {
+		decoded.RelatedID = extensionString(extensions[relatedIDExtension])
+	}
+	if len(decoded.Extensions) == 0 {
+		decoded.Extensions = nil
+	}
+
+	*c = *decoded
+	return nil
+}
+
+// jsonMembers returns the set of member names declared by the json tags of
+// the wire representation w
+func jsonMembers(w interface{}) map[string]bool {
+
+	members := make(map[string]bool)
+	t := reflect.TypeOf(w).Elem()
+
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if len(name) > 0 {
+			members[name] = true
+		}
+	}
+	return members
+}
+
+// decodeExtension returns the Go value of a JSON extension attribute, with
+// integral numbers as int64 rather than float64
+func decodeExtension(member json.RawMessage) interface{} {
+
+	var v interface{}
+
+	d := json.NewDecoder(bytes.NewReader(member))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil
+	}
+
+	if n, ok := v.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		f, _ := n.Float64()
+		return f
+	}
+	return v
+}
+
+func isJSONNull(member json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(member), []byte("null"))
+}
+
+// jsonExtensions returns extensions with URI values converted to the strings
+// the JSON format represents them as
+func jsonExtensions(extensions map[string]interface{}) map[string]interface{} {
+
+	if len(extensions) == 0 {
+		return extensions
+	}
+
+	ret := make(map[string]interface{})
+	for name, v := range extensions {
+		switch u := v.(type) {
+		case *url.URL:
+			ret[name] = u.String()
+		case url.URL:
+			ret[name] = u.String()
+		default:
+			ret[name] = v
+		}
+	}
+	return ret
+}
+
+// extensionString returns the canonical string form of an extension value,
+// as used in ce- headers and when an extension is promoted to an attribute
+func extensionString(v interface{}) string {
+
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	case *url.URL:
+		return t.String()
+	case url.URL:
+		return t.String()
+	case []byte:
+		return base64.StdEncoding.EncodeToString(t)
+	}
+	return fmt.Sprint(v)
+}
+
+// isExtensionValue reports whether v is of one of the attribute types an
+// extension may take: string, integer, boolean, URI, timestamp or binary
+func isExtensionValue(v interface{}) bool {
+
+	switch t := v.(type) {
+	case string, bool, int32, time.Time, *url.URL, url.URL, []byte:
+		return true
+	case int:
+		return t >= math.MinInt32 && t <= math.MaxInt32
+	case int64:
+		return t >= math.MinInt32 && t <= math.MaxInt32
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package function
+
+import (
+	"encoding/binary"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// parseContentType returns the RFC 7231 media type, lower-cased, and parameters
+// of the request's Content-Type.  A request without one has an empty media type.
+// https://tools.ietf.org/html/rfc7231#section-3.1.1.1
+func parseContentType(httpContentTypes []string) (string, map[string]string, error) {
+
+	if len(httpContentTypes) == 0 || len(strings.TrimSpace(httpContentTypes[0])) == 0 {
+		return "", map[string]string{}, nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(httpContentTypes[0])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid Content-Type %q: %s", httpContentTypes[0], err)
+	}
+
+	return mediaType, params, nil
+}
+
+// decodeCharset returns body transcoded from charset to UTF-8.  An empty
+// charset is taken to be UTF-8, the default for the JSON based formats.
+func decodeCharset(body []byte, charset string) ([]byte, error) {
+
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return body, nil
+	case "iso-8859-1", "latin1":
+		// ISO-8859-1 code points are the first 256 of Unicode
+		ret := make([]byte, 0, len(body))
+		for _, b := range body {
+			ret = append(ret, string(rune(b))...)
+		}
+		return ret, nil
+	case "utf-16", "utf-16be", "utf-16le":
+		return decodeUTF16(body, strings.ToLower(charset))
+	}
+
+	return nil, fmt.Errorf("unsupported charset: %s", charset)
+}
+
+// decodeUTF16 transcodes body to UTF-8, honouring any byte order mark and
+// otherwise defaulting to big endian as RFC 2781 requires for utf-16
+func decodeUTF16(body []byte, charset string) ([]byte, error) {
+
+	if len(body)%2 != 0 {
+		return nil, fmt.Errorf("invalid %s body: odd number of bytes", charset)
+	}
+
+	var order binary.ByteOrder = binary.BigEndian
+	if charset == "utf-16le" {
+		order = binary.LittleEndian
+	}
+
+	if charset == "utf-16" && len(body) >= 2 {
+		switch {
+		case body[0] == 0xFE && body[1] == 0xFF:
+			body = body[2:]
+		case body[0] == 0xFF && body[1] == 0xFE:
+			order, body = binary.LittleEndian, body[2:]
+		}
+	}
+
+	units := make([]uint16, len(body)/2)
+	for i := range units {
+		units[i] = order.Uint16(body[i*2:])
+	}
+
+	ret := make([]byte, 0, len(body))
+	for _, r := range utf16.Decode(units) {
+		var buf [utf8.UTFMax]byte
+		n := utf8.EncodeRune(buf[:], r)
+		ret = append(ret, buf[:n]...)
+	}
+	return ret, nil
+}
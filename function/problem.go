@@ -0,0 +1,111 @@
+package function
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/docker/distribution/uuid"
+	"github.com/openfaas-incubator/go-function-sdk"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	errorEventType     = "io.madlib.error"
+)
+
+// problem is an RFC 7807 problem details body describing why a request
+// could not be handled
+// https://tools.ietf.org/html/rfc7807
+type problem struct {
+	Type       string      `json:"type"`
+	Title      string      `json:"title"`
+	Status     int         `json:"status"`
+	Detail     string      `json:"detail,omitempty"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// newProblem returns the problem details describing err.  A *ValidationError
+// has each of its violations listed.
+func newProblem(statusCode int, err error) *problem {
+
+	p := &problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(statusCode),
+		Status: statusCode,
+		Detail: err.Error(),
+	}
+
+	if v, ok := err.(*ValidationError); ok {
+		p.Detail = "the request is not a valid CloudEvent"
+		p.Violations = v.Violations
+	}
+
+	return p
+}
+
+// sendProblem returns a response carrying a problem details body for err
+func sendProblem(statusCode int, err error) (handler.Response, error) {
+
+	bMessage, marshalErr := json.Marshal(newProblem(statusCode, err))
+	if marshalErr != nil {
+		return handler.Response{}, marshalErr
+	}
+
+	return handler.Response{
+		Body:       bMessage,
+		StatusCode: statusCode,
+		Header: map[string][]string{
+			"Content-Type": []string{problemContentType},
+		},
+	}, nil
+}
+
+// initErrorEvent returns an event reporting that the request event reqID
+// could not be handled, with the problem details as its data
+func initErrorEvent(specVersion string, reqID string, statusCode int, err error) *CloudEvent {
+
+	dataField, marshalErr := json.Marshal(newProblem(statusCode, err))
+
+	if marshalErr != nil {
+		dataField = nil
+	}
+
+	return &CloudEvent{
+		Type:            errorEventType,
+		SpecVersion:     specVersion,
+		Source:          fnSource,
+		ID:              uuid.Generate().String(),
+		RelatedID:       reqID,
+		Time:            time.Now(),
+		DataContentType: problemContentType,
+		Data:            dataField,
+	}
+}
+
+// sendErrorEvent returns a response carrying an error event for the request
+// event c, encoded in the same mode as the request
+func sendErrorEvent(c *CloudEvent, format *eventFormat, statusCode int, err error) (handler.Response, error) {
+
+	var (
+		bMessage   []byte
+		headerVals map[string][]string
+	)
+
+	errEvent := initErrorEvent(c.SpecVersion, c.ID, statusCode, err)
+
+	if format != nil {
+		bMessage, headerVals, err = setStructuredCloudEvent(errEvent, format)
+	} else {
+		bMessage, headerVals, err = setBinaryCloudEvent(errEvent)
+	}
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	return handler.Response{
+		Body:       bMessage,
+		StatusCode: statusCode,
+		Header:     headerVals,
+	}, nil
+}
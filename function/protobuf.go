@@ -0,0 +1,365 @@
+package function
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+const protobufMediaType = "application/cloudevents+protobuf"
+
+// Field numbers of the CloudEvent message and its nested messages
+// https://github.com/cloudevents/spec/blob/v1.0.1/spec.proto
+const (
+	pbID          = 1
+	pbSource      = 2
+	pbSpecVersion = 3
+	pbType        = 4
+	pbAttributes  = 5
+	pbBinaryData  = 6
+	pbTextData    = 7
+
+	pbMapKey   = 1
+	pbMapValue = 2
+
+	pbBoolean   = 1
+	pbInteger   = 2
+	pbString    = 3
+	pbBytes     = 4
+	pbURI       = 5
+	pbURIRef    = 6
+	pbTimestamp = 7
+
+	pbSeconds = 1
+	pbNanos   = 2
+)
+
+// Protobuf wire types
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbLength  = 2
+	pbFixed32 = 5
+)
+
+func init() {
+
+	registerEventFormat(&eventFormat{
+		mediaType:   protobufMediaType,
+		contentType: protobufMediaType,
+		marshal:     marshalProtobuf,
+		unmarshal:   unmarshalProtobuf,
+	})
+
+}
+
+// marshalProtobuf encodes c as a CloudEvent protobuf message.  The format is
+// only defined for v1.0 so events in other versions are translated first.
+func marshalProtobuf(c *CloudEvent) ([]byte, error) {
+
+	c, err := translateCloudEvent(c, specVersion10)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	b = appendProtoString(b, pbID, c.ID)
+	b = appendProtoString(b, pbSource, c.Source)
+	b = appendProtoString(b, pbSpecVersion, c.SpecVersion)
+	b = appendProtoString(b, pbType, c.Type)
+
+	attributes := make(map[string]interface{})
+	for name, v := range c.Extensions {
+		attributes[name] = v
+	}
+	if !c.Time.IsZero() {
+		attributes["time"] = c.Time
+	}
+	if len(c.Subject) > 0 {
+		attributes["subject"] = c.Subject
+	}
+	if len(c.DataSchema) > 0 {
+		if u, err := url.Parse(c.DataSchema); err == nil {
+			attributes["dataschema"] = u
+		}
+	}
+	if len(c.DataContentType) > 0 {
+		attributes["datacontenttype"] = c.DataContentType
+	}
+	if len(c.RelatedID) > 0 {
+		attributes[relatedIDExtension] = c.RelatedID
+	}
+
+	// Sorted so that the same event always encodes to the same bytes
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, err := protoAttributeValue(attributes[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+
+		var entry []byte
+		entry = appendProtoString(entry, pbMapKey, name)
+		entry = appendProtoBytes(entry, pbMapValue, value)
+		b = appendProtoBytes(b, pbAttributes, entry)
+	}
+
+	switch {
+	case len(c.Data) == 0:
+	case isTextContentType(c.DataContentType) && utf8.Valid(c.Data):
+		b = appendProtoBytes(b, pbTextData, c.Data)
+	default:
+		b = appendProtoBytes(b, pbBinaryData, c.Data)
+	}
+
+	return b, nil
+}
+
+// protoAttributeValue encodes v as a CloudEventAttributeValue message
+func protoAttributeValue(v interface{}) ([]byte, error) {
+
+	var b []byte
+
+	switch t := v.(type) {
+	case bool:
+		var i uint64
+		if t {
+			i = 1
+		}
+		return appendProtoVarint(b, pbBoolean, i), nil
+	case int32:
+		return appendProtoVarint(b, pbInteger, uint64(int64(t))), nil
+	case int:
+		return appendProtoVarint(b, pbInteger, uint64(int64(int32(t)))), nil
+	case int64:
+		return appendProtoVarint(b, pbInteger, uint64(int64(int32(t)))), nil
+	case string:
+		return appendProtoBytes(b, pbString, []byte(t)), nil
+	case []byte:
+		return appendProtoBytes(b, pbBytes, t), nil
+	case *url.URL:
+		if t.IsAbs() {
+			return appendProtoBytes(b, pbURI, []byte(t.String())), nil
+		}
+		return appendProtoBytes(b, pbURIRef, []byte(t.String())), nil
+	case url.URL:
+		return protoAttributeValue(&t)
+	case time.Time:
+		var ts []byte
+		ts = appendProtoVarint(ts, pbSeconds, uint64(t.Unix()))
+		ts = appendProtoVarint(ts, pbNanos, uint64(t.Nanosecond()))
+		return appendProtoBytes(b, pbTimestamp, ts), nil
+	}
+	return nil, fmt.Errorf("unsupported attribute type %T", v)
+}
+
+// unmarshalProtobuf decodes a CloudEvent protobuf message
+func unmarshalProtobuf(b []byte) (*CloudEvent, error) {
+
+	c := &CloudEvent{}
+	attributes := make(map[string]interface{})
+
+	err := readProtoFields(b, func(field int, wireType int, varint uint64, value []byte) error {
+
+		switch field {
+		case pbID:
+			c.ID = string(value)
+		case pbSource:
+			c.Source = string(value)
+		case pbSpecVersion:
+			c.SpecVersion = string(value)
+		case pbType:
+			c.Type = string(value)
+		case pbAttributes:
+			name, v, err := readProtoAttribute(value)
+			if err != nil {
+				return err
+			}
+			attributes[name] = v
+		case pbBinaryData, pbTextData:
+			c.Data = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, v := range attributes {
+		switch name {
+		case "time":
+			if t, ok := v.(time.Time); ok {
+				c.Time = t
+			} else {
+				c.rawTime = extensionString(v)
+				c.Time, _ = time.Parse(time.RFC3339, c.rawTime)
+			}
+		case "subject":
+			c.Subject = extensionString(v)
+		case "dataschema":
+			c.DataSchema = extensionString(v)
+		case "datacontenttype":
+			c.DataContentType = extensionString(v)
+		case relatedIDExtension:
+			c.RelatedID = extensionString(v)
+		default:
+			if c.Extensions == nil {
+				c.Extensions = make(map[string]interface{})
+			}
+			c.Extensions[name] = v
+		}
+	}
+
+	return c, nil
+}
+
+// readProtoAttribute decodes an entry of the attributes map
+func readProtoAttribute(b []byte) (string, interface{}, error) {
+
+	var (
+		name  string
+		value interface{}
+	)
+
+	err := readProtoFields(b, func(field int, wireType int, varint uint64, entry []byte) error {
+
+		switch field {
+		case pbMapKey:
+			name = string(entry)
+		case pbMapValue:
+			return readProtoFields(entry, func(field int, wireType int, varint uint64, attr []byte) error {
+
+				switch field {
+				case pbBoolean:
+					value = varint != 0
+				case pbInteger:
+					value = int32(varint)
+				case pbString:
+					value = string(attr)
+				case pbBytes:
+					value = attr
+				case pbURI, pbURIRef:
+					u, err := url.Parse(string(attr))
+					if err != nil {
+						return err
+					}
+					value = u
+				case pbTimestamp:
+					var seconds, nanos uint64
+					err := readProtoFields(attr, func(field int, wireType int, varint uint64, _ []byte) error {
+						switch field {
+						case pbSeconds:
+							seconds = varint
+						case pbNanos:
+							nanos = varint
+						}
+						return nil
+					})
+					if err != nil {
+						return err
+					}
+					value = time.Unix(int64(seconds), int64(int32(nanos))).UTC()
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+
+	return name, value, err
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendProtoKey(b []byte, field int, wireType int) []byte {
+	return appendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendProtoVarint(b []byte, field int, v uint64) []byte {
+	return appendUvarint(appendProtoKey(b, field, pbVarint), v)
+}
+
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+
+	b = appendUvarint(appendProtoKey(b, field, pbLength), uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendProtoString appends a string field, omitting it when empty as proto3 does
+func appendProtoString(b []byte, field int, v string) []byte {
+
+	if len(v) == 0 {
+		return b
+	}
+	return appendProtoBytes(b, field, []byte(v))
+}
+
+// readProtoFields calls fn for each field of the message in b, passing the
+// value of varint fields in varint and the contents of length delimited
+// fields in value.  Fixed width fields are skipped over.
+func readProtoFields(b []byte, fn func(field int, wireType int, varint uint64, value []byte) error) error {
+
+	for len(b) > 0 {
+
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("protobuf: malformed field key")
+		}
+		b = b[n:]
+
+		field, wireType := int(key>>3), int(key&7)
+
+		var (
+			varint uint64
+			value  []byte
+		)
+
+		switch wireType {
+		case pbVarint:
+			varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("protobuf: malformed varint in field %d", field)
+			}
+			b = b[n:]
+		case pbLength:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return fmt.Errorf("protobuf: malformed length in field %d", field)
+			}
+			value, b = b[n:n+int(length)], b[n+int(length):]
+		case pbFixed64:
+			if len(b) < 8 {
+				return fmt.Errorf("protobuf: truncated field %d", field)
+			}
+			b = b[8:]
+			continue
+		case pbFixed32:
+			if len(b) < 4 {
+				return fmt.Errorf("protobuf: truncated field %d", field)
+			}
+			b = b[4:]
+			continue
+		default:
+			return fmt.Errorf("protobuf: unsupported wire type %d in field %d", wireType, field)
+		}
+
+		if err := fn(field, wireType, varint, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
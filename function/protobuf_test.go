@@ -0,0 +1,252 @@
+package function
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"math/rand"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func Test_Protobuf_RoundTrip(t *testing.T) {
+
+	eventTime := time.Date(2018, 4, 5, 17, 31, 0, 123456789, time.UTC)
+	extTime := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	c := &CloudEvent{
+		Type:            "com.example.someevent",
+		SpecVersion:     specVersion10,
+		Source:          "/mycontext/subcontext",
+		ID:              "1234-1234-1234",
+		Time:            eventTime,
+		Subject:         "greeting",
+		DataSchema:      "https://example.com/schema",
+		RelatedID:       "abc",
+		DataContentType: "text/plain",
+		Extensions: map[string]interface{}{
+			"boolext":   true,
+			"falseext":  false,
+			"intext":    int32(-42),
+			"minext":    int32(math.MinInt32),
+			"maxext":    int32(math.MaxInt32),
+			"stringext": "value",
+			"bytesext":  []byte{0, 1, 2, 0xff},
+			"uriext":    mustParseURL(t, "https://example.com/x?y=z"),
+			"urirefext": mustParseURL(t, "/some/ref"),
+			"timeext":   extTime,
+		},
+		Data: []byte("hello, world"),
+	}
+
+	b, err := marshalProtobuf(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := unmarshalProtobuf(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Type != c.Type || got.SpecVersion != c.SpecVersion || got.Source != c.Source || got.ID != c.ID ||
+		got.Subject != c.Subject || got.DataSchema != c.DataSchema || got.RelatedID != c.RelatedID ||
+		got.DataContentType != c.DataContentType {
+		t.Errorf("attributes not round-tripped, want: %+v got: %+v", c, got)
+	}
+
+	if !got.Time.Equal(eventTime) {
+		t.Errorf("time want: %s got: %s", eventTime.Format(time.RFC3339Nano), got.Time.Format(time.RFC3339Nano))
+	}
+
+	if !bytes.Equal(got.Data, c.Data) {
+		t.Errorf("data want: %q got: %q", c.Data, got.Data)
+	}
+
+	if gotTime, ok := got.Extensions["timeext"].(time.Time); !ok || !gotTime.Equal(extTime) {
+		t.Errorf("timeext want: %s got: %#v", extTime, got.Extensions["timeext"])
+	}
+	delete(got.Extensions, "timeext")
+	delete(c.Extensions, "timeext")
+
+	if !reflect.DeepEqual(got.Extensions, c.Extensions) {
+		t.Errorf("extensions want: %#v got: %#v", c.Extensions, got.Extensions)
+	}
+}
+
+func Test_Protobuf_BinaryData(t *testing.T) {
+
+	c := &CloudEvent{
+		Type:            "com.example.binary",
+		SpecVersion:     specVersion10,
+		Source:          "urn:example:source",
+		ID:              "bin-1",
+		DataContentType: "application/octet-stream",
+		Data:            []byte{0xde, 0xad, 0xbe, 0xef, 0x00},
+	}
+
+	b, err := marshalProtobuf(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := unmarshalProtobuf(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got.Data, c.Data) {
+		t.Errorf("data want: %x got: %x", c.Data, got.Data)
+	}
+}
+
+// protobufVectors were encoded by google.golang.org/protobuf from the
+// CloudEvent message of the spec's cloudevents.proto, marshalling
+// deterministically so that attributes are sorted by name
+var protobufVectors = []struct {
+	title   string
+	encoded string
+	want    *CloudEvent
+}{
+	{
+		title:   "Text data and every attribute type",
+		encoded: "0a0e313233342d313233342d3132333412152f6d79636f6e746578742f737562636f6e746578741a03312e302215636f6d2e6578616d706c652e736f6d656576656e742a0d0a07626f6f6c657874120208012a120a08627974657365787412062204000102ff2a1f0a0f64617461636f6e74656e7474797065120c1a0a746578742f706c61696e2a2a0a0a64617461736368656d61121c2a1a68747470733a2f2f6578616d706c652e636f6d2f736368656d612a150a06696e74657874120b10d6ffffffffffffffff012a150a077375626a656374120a1a086772656574696e672a150a0474696d65120d3a0b08d4ba99d60510959aef3a2a180a09757269726566657874120b32092f736f6d652f7265663a0c68656c6c6f2c20776f726c64",
+		want: &CloudEvent{
+			Type:            "com.example.someevent",
+			SpecVersion:     specVersion10,
+			Source:          "/mycontext/subcontext",
+			ID:              "1234-1234-1234",
+			Time:            time.Date(2018, 4, 5, 17, 31, 0, 123456789, time.UTC),
+			Subject:         "greeting",
+			DataSchema:      "https://example.com/schema",
+			DataContentType: "text/plain",
+			Extensions: map[string]interface{}{
+				"boolext":   true,
+				"intext":    int32(-42),
+				"bytesext":  []byte{0, 1, 2, 0xff},
+				"urirefext": &url.URL{Path: "/some/ref"},
+			},
+			Data: []byte("hello, world"),
+		},
+	},
+	{
+		title:   "Binary data",
+		encoded: "0a0562696e2d31121275726e3a6578616d706c653a736f757263651a03312e302212636f6d2e6578616d706c652e62696e6172792a2d0a0f64617461636f6e74656e7474797065121a1a186170706c69636174696f6e2f6f637465742d73747265616d3205deadbeef00",
+		want: &CloudEvent{
+			Type:            "com.example.binary",
+			SpecVersion:     specVersion10,
+			Source:          "urn:example:source",
+			ID:              "bin-1",
+			DataContentType: "application/octet-stream",
+			Data:            []byte{0xde, 0xad, 0xbe, 0xef, 0x00},
+		},
+	},
+}
+
+func Test_Protobuf_ReferenceEncodings(t *testing.T) {
+
+	for _, test := range protobufVectors {
+		t.Run(test.title, func(t *testing.T) {
+
+			encoded, err := hex.DecodeString(test.encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := unmarshalProtobuf(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !got.Time.Equal(test.want.Time) {
+				t.Errorf("time want: %s got: %s", test.want.Time, got.Time)
+			}
+			got.Time = test.want.Time
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("want: %#v got: %#v", test.want, got)
+			}
+
+			// The same event should encode to the same bytes
+			b, err := marshalProtobuf(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, encoded) {
+				t.Errorf("encoding want: %x got: %x", encoded, b)
+			}
+		})
+	}
+}
+
+func Test_Protobuf_MalformedInput(t *testing.T) {
+
+	valid, err := marshalProtobuf(&CloudEvent{
+		Type:        "com.example.someevent",
+		SpecVersion: specVersion10,
+		Source:      "/mycontext",
+		ID:          "1",
+		Time:        time.Now(),
+		Extensions:  map[string]interface{}{"ext": "value"},
+		Data:        []byte(`{"word":"cat"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncating at a field boundary leaves a valid message, but no
+	// truncation may panic
+	for i := 1; i < len(valid); i++ {
+		unmarshalProtobuf(valid[:i])
+	}
+
+	// Nor may garbage
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		garbage := make([]byte, rng.Intn(64))
+		rng.Read(garbage)
+		unmarshalProtobuf(garbage)
+	}
+
+	// The data field runs to the end, so cutting it short is an error
+	if _, err := unmarshalProtobuf(valid[:len(valid)-1]); err == nil {
+		t.Errorf("truncated data: want an error")
+	}
+
+	var tests = []struct {
+		title string
+		input []byte
+	}{
+		{"Unterminated key", []byte{0x80}},
+		{"Unterminated varint", []byte{0x08, 0xff}},
+		{"Length beyond the input", []byte{0x0a, 0x05, 'a'}},
+		{"Overlong length", []byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"Group wire type", []byte{0x0b}},
+		{"Truncated fixed64", []byte{0x09, 1, 2, 3}},
+		{"Truncated fixed32", []byte{0x0d, 1}},
+		{"Malformed attribute", []byte{0x2a, 0x02, 0x0a, 0x05}},
+		{"Malformed attribute value", []byte{0x2a, 0x04, 0x12, 0x02, 0x1a, 0x05}},
+		{"Malformed timestamp", []byte{0x2a, 0x06, 0x12, 0x04, 0x3a, 0x02, 0x08, 0xff}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			if _, err := unmarshalProtobuf(test.input); err == nil {
+				t.Errorf("want an error decoding %x", test.input)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const routesFileEnvVar = "routesFile"
+
+// defaultRoutes answers word requests of any io.madlib.found.<wordtype> style
+// type, and templates, when routesFile isn't set
+const defaultRoutes = `{"routes": [
+	{"type": "io.madlib.template.submitted", "action": "template", "responseType": "io.madlib.template.rendered"},
+	{"type": "*found.{wordType}", "action": "word", "responseType": "{1}picked.{wordType}"}
+]}`
+
+// routeAction produces the data of the response to c matched by m
+type routeAction func(c *CloudEvent, wordList WordList, m *routeMatch, rng *rand.Rand) (interface{}, int, error)
+
+// routeActions are the actions routes may name
+var routeActions = map[string]routeAction{
+	"word":     pickWord,
+	"template": renderTemplate,
+}
+
+// route sends request events whose type matches either the type pattern or
+// typeRegex to an action, replying with an event of responseType.  In type
+// patterns * matches any run of characters and {name} a single dot separated
+// segment.  What they match, and the groups of typeRegex, are captured by
+// name or position and may be used as {name} or {1} in responseType and
+// wordType.
+type route struct {
+	Type         string `json:"type"`
+	TypeRegex    string `json:"typeRegex"`
+	Action       string `json:"action"`
+	ResponseType string `json:"responseType"`
+	WordType     string `json:"wordType"`
+
+	pattern *regexp.Regexp
+	action  routeAction
+}
+
+// routeMatch is a route matched by a request event, with what it captured
+type routeMatch struct {
+	route    *route
+	captures map[string]string
+}
+
+// noRouteError reports a request event of a type with no route
+type noRouteError struct {
+	eventType string
+}
+
+func (e *noRouteError) Error() string {
+	return fmt.Sprintf("no route for event type %s", e.eventType)
+}
+
+var (
+	routeParamPattern = regexp.MustCompile(`{([A-Za-z0-9_]+)}`)
+	typeWildcards     = regexp.MustCompile(`\*|{([A-Za-z0-9_]+)}`)
+
+	routes = loadRoutes()
+)
+
+// loadRoutes returns the routes in routesFile, or the default routes when it
+// is unset.  A routesFile that can't be loaded stops the function starting,
+// rather than have it answer requests by a table it wasn't given.
+func loadRoutes() []*route {
+
+	body := []byte(defaultRoutes)
+
+	path := os.Getenv(routesFileEnvVar)
+	if len(path) > 0 {
+		var err error
+		if body, err = ioutil.ReadFile(path); err != nil {
+			panic(fmt.Sprintf("%s: %s", routesFileEnvVar, err))
+		}
+	}
+
+	table, err := parseRoutes(body)
+	if err != nil {
+		if len(path) > 0 {
+			panic(fmt.Sprintf("%s: %s: %s", routesFileEnvVar, path, err))
+		}
+		panic(err)
+	}
+	return table
+}
+
+// parseRoutes decodes and compiles a JSON routing table
+func parseRoutes(body []byte) ([]*route, error) {
+
+	var table struct {
+		Routes []*route `json:"routes"`
+	}
+
+	if err := json.Unmarshal(body, &table); err != nil {
+		return nil, err
+	}
+	if len(table.Routes) == 0 {
+		return nil, fmt.Errorf("no routes")
+	}
+
+	for i, r := range table.Routes {
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("route %d: %s", i+1, err)
+		}
+	}
+
+	return table.Routes, nil
+}
+
+// compile checks the route, turning its type pattern into a regular
+// expression and looking up its action
+func (r *route) compile() error {
+
+	var (
+		expr string
+		err  error
+	)
+
+	switch {
+	case len(r.Type) > 0 && len(r.TypeRegex) > 0:
+		return fmt.Errorf("both type and typeRegex given")
+	case len(r.Type) > 0:
+		expr = typePatternRegex(r.Type)
+	case len(r.TypeRegex) > 0:
+		expr = r.TypeRegex
+	default:
+		return fmt.Errorf("no type or typeRegex given")
+	}
+
+	if r.pattern, err = regexp.Compile("^(?:" + expr + ")$"); err != nil {
+		return err
+	}
+
+	var ok bool
+	if r.action, ok = routeActions[r.Action]; !ok {
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	if len(r.ResponseType) == 0 {
+		return fmt.Errorf("no responseType given")
+	}
+	if len(r.WordType) == 0 {
+		r.WordType = "{wordType}"
+	}
+
+	params := map[string]bool{"0": true}
+	for i, name := range r.pattern.SubexpNames()[1:] {
+		params[strconv.Itoa(i+1)] = true
+		params[name] = true
+	}
+
+	templates := []string{r.ResponseType}
+	if r.Action == "word" {
+		templates = append(templates, r.WordType)
+	}
+	for _, t := range templates {
+		for _, m := range routeParamPattern.FindAllStringSubmatch(t, -1) {
+			if !params[m[1]] {
+				return fmt.Errorf("%s uses {%s}, which the type doesn't capture", t, m[1])
+			}
+		}
+	}
+
+	return nil
+}
+
+// typePatternRegex returns the regular expression matching the type pattern
+func typePatternRegex(pattern string) string {
+
+	var expr strings.Builder
+	last := 0
+
+	for _, m := range typeWildcards.FindAllStringSubmatchIndex(pattern, -1) {
+		expr.WriteString(regexp.QuoteMeta(pattern[last:m[0]]))
+		if m[2] < 0 {
+			expr.WriteString("(.*)")
+		} else {
+			expr.WriteString("(?P<" + pattern[m[2]:m[3]] + ">[^.]+)")
+		}
+		last = m[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(pattern[last:]))
+
+	return expr.String()
+}
+
+// routeEvent returns the first route matching the type of c
+func routeEvent(c *CloudEvent) (*routeMatch, error) {
+
+	for _, r := range routes {
+		m := r.pattern.FindStringSubmatch(c.Type)
+		if m == nil {
+			continue
+		}
+
+		captures := make(map[string]string, len(m))
+		for i, name := range r.pattern.SubexpNames() {
+			captures[strconv.Itoa(i)] = m[i]
+			if len(name) > 0 {
+				captures[name] = m[i]
+			}
+		}
+		return &routeMatch{route: r, captures: captures}, nil
+	}
+
+	return nil, &noRouteError{eventType: c.Type}
+}
+
+// expand fills the {name} parameters of template with what the route captured
+func (m *routeMatch) expand(template string) string {
+
+	return routeParamPattern.ReplaceAllStringFunc(template, func(param string) string {
+		return m.captures[param[1:len(param)-1]]
+	})
+}
+
+func (m *routeMatch) responseType() string {
+	return m.expand(m.route.ResponseType)
+}
+
+func (m *routeMatch) wordType() string {
+	return m.expand(m.route.WordType)
+}
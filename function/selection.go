@@ -0,0 +1,285 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	tagsExtension       = "tags"
+	localeExtension     = "locale"
+	seedExtension       = "seed"
+	maxWordCountEnvVar  = "maxWordCount"
+	defaultMaxWordCount = 100
+)
+
+// wordFilter narrows the words a request may be answered with to those
+// carrying all of tags, matching locale when set, not in exclude and with a
+// length, in characters, within minLength and maxLength when set
+type wordFilter struct {
+	Tags      []string `json:"tags,omitempty"`
+	Locale    string   `json:"locale,omitempty"`
+	Exclude   []string `json:"exclude,omitempty"`
+	MinLength int      `json:"minLength,omitempty"`
+	MaxLength int      `json:"maxLength,omitempty"`
+}
+
+// matches reports whether w passes the filter.  A requested locale such as
+// "en" matches words in any of its regions such as "en-GB", and words with no
+// locale match any locale.
+func (f *wordFilter) matches(w *Word) bool {
+
+	for _, tag := range f.Tags {
+		if !containsFold(w.Tags, tag) {
+			return false
+		}
+	}
+
+	if containsFold(f.Exclude, w.Word) {
+		return false
+	}
+
+	length := utf8.RuneCountInString(w.Word)
+	if (f.MinLength > 0 && length < f.MinLength) || (f.MaxLength > 0 && length > f.MaxLength) {
+		return false
+	}
+
+	if len(f.Locale) == 0 || len(w.Locale) == 0 {
+		return true
+	}
+	return strings.EqualFold(w.Locale, f.Locale) ||
+		strings.HasPrefix(strings.ToLower(w.Locale), strings.ToLower(f.Locale)+"-")
+}
+
+func (f *wordFilter) String() string {
+
+	var criteria []string
+	if len(f.Tags) > 0 {
+		criteria = append(criteria, "tags "+strings.Join(f.Tags, ", "))
+	}
+	if len(f.Locale) > 0 {
+		criteria = append(criteria, "locale "+f.Locale)
+	}
+	if len(f.Exclude) > 0 {
+		criteria = append(criteria, "none of "+strings.Join(f.Exclude, ", "))
+	}
+	if f.MinLength > 0 {
+		criteria = append(criteria, fmt.Sprintf("at least %d characters", f.MinLength))
+	}
+	if f.MaxLength > 0 {
+		criteria = append(criteria, fmt.Sprintf("at most %d characters", f.MaxLength))
+	}
+	return strings.Join(criteria, " and ")
+}
+
+// wordSelection is what a request asks to be picked: count words passing the
+// filter, without repeats when unique is set.  A request giving any of these
+// selection parameters is answered with the words as an array and the
+// parameters echoed back, otherwise with a single word.
+type wordSelection struct {
+	wordFilter
+	Count  int  `json:"count"`
+	Unique bool `json:"unique"`
+
+	multiple bool
+}
+
+// describe names the words of wordType the filter allows, for errors
+func (f *wordFilter) describe(wordType string) string {
+
+	if criteria := f.String(); len(criteria) > 0 {
+		return wordType + " with " + criteria
+	}
+	return wordType
+}
+
+// wordsData is the data of a response to a request for multiple words
+type wordsData struct {
+	Words []string `json:"words"`
+	*wordSelection
+}
+
+// extractWordSelection returns the words the request asks for.  Tags and
+// locale may be given as the tags (comma separated) and locale extensions or
+// as members of JSON data, which take precedence.  The other selection
+// parameters may only be given in JSON data.
+func extractWordSelection(c *CloudEvent) (*wordSelection, error) {
+
+	selection := &wordSelection{Count: 1}
+
+	if v, ok := c.Extensions[tagsExtension]; ok {
+		selection.Tags = splitList(extensionString(v))
+	}
+	if v, ok := c.Extensions[localeExtension]; ok {
+		selection.Locale = extensionString(v)
+	}
+
+	// Data other than a JSON object carries no selection parameters
+	raw, isJSON := jsonData(c)
+	if !isJSON || !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		return selection, nil
+	}
+
+	var data struct {
+		Tags      interface{} `json:"tags"`
+		Locale    string      `json:"locale"`
+		Count     *int        `json:"count"`
+		Unique    *bool       `json:"unique"`
+		Exclude   []string    `json:"exclude"`
+		MinLength *int        `json:"minLength"`
+		MaxLength *int        `json:"maxLength"`
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("invalid word selection: %s", err)
+	}
+
+	switch tags := data.Tags.(type) {
+	case string:
+		selection.Tags = splitList(tags)
+	case []interface{}:
+		selection.Tags = nil
+		for _, tag := range tags {
+			if t, ok := tag.(string); ok {
+				selection.Tags = append(selection.Tags, t)
+			}
+		}
+	}
+	if len(data.Locale) > 0 {
+		selection.Locale = data.Locale
+	}
+
+	selection.multiple = data.Count != nil || data.Unique != nil || data.Exclude != nil ||
+		data.MinLength != nil || data.MaxLength != nil
+
+	if data.Count != nil {
+		maxCount := envInt(maxWordCountEnvVar, defaultMaxWordCount)
+		if *data.Count < 1 || *data.Count > maxCount {
+			return nil, fmt.Errorf("invalid word selection: count must be between 1 and %d", maxCount)
+		}
+		selection.Count = *data.Count
+	}
+	if data.Unique != nil {
+		selection.Unique = *data.Unique
+	}
+	selection.Exclude = data.Exclude
+
+	if data.MinLength != nil {
+		if *data.MinLength < 0 {
+			return nil, fmt.Errorf("invalid word selection: minLength must not be negative")
+		}
+		selection.MinLength = *data.MinLength
+	}
+	if data.MaxLength != nil {
+		if *data.MaxLength < 1 {
+			return nil, fmt.Errorf("invalid word selection: maxLength must be at least 1")
+		}
+		selection.MaxLength = *data.MaxLength
+	}
+	if selection.MaxLength > 0 && selection.MinLength > selection.MaxLength {
+		return nil, fmt.Errorf("invalid word selection: minLength is greater than maxLength")
+	}
+
+	return selection, nil
+}
+
+// requestRand returns the source of randomness for picking words for c.  It
+// is seeded by the seed extension, an integer or any other string, or failing
+// that by the event's id, so the same request event is always answered with
+// the same words.
+func requestRand(c *CloudEvent) *rand.Rand {
+
+	seed := c.ID
+
+	if v, ok := c.Extensions[seedExtension]; ok {
+		seed = extensionString(v)
+		if n, err := strconv.ParseInt(seed, 10, 64); err == nil {
+			return rand.New(rand.NewSource(n))
+		}
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// getWordValue picks words of wordType from wordList as selection asks,
+// returning the data of the response event or an error saying why there
+// aren't enough words
+func getWordValue(wordList []Word, wordType string, selection *wordSelection, rng *rand.Rand) (interface{}, error) {
+
+	picked := pickWords(wordList, selection, rng)
+
+	if len(picked) < selection.Count {
+		if len(picked) == 0 {
+			return nil, fmt.Errorf("no words available of type %s", selection.describe(wordType))
+		}
+		return nil, fmt.Errorf("only %d different words available of type %s, %d asked for", len(picked), selection.describe(wordType), selection.Count)
+	}
+
+	if !selection.multiple {
+		return map[string]string{"word": picked[0]}, nil
+	}
+	return &wordsData{Words: picked, wordSelection: selection}, nil
+}
+
+// pickWords picks up to selection.Count words passing its filter at random,
+// in proportion to their weights.  Unique selections never pick the same word
+// twice, so may run out of words.
+func pickWords(wordList []Word, selection *wordSelection, rng *rand.Rand) []string {
+
+	var (
+		candidates []*Word
+		total      float64
+	)
+
+	for i := range wordList {
+		if w := &wordList[i]; selection.matches(w) && w.weight() > 0 {
+			candidates = append(candidates, w)
+			total += w.weight()
+		}
+	}
+
+	var picked []string
+
+	for len(picked) < selection.Count && len(candidates) > 0 {
+		i := pickWeighted(candidates, total, rng)
+		w := candidates[i]
+		picked = append(picked, w.Word)
+
+		if selection.Unique {
+			// Drop every entry for the word, which may be listed more than once
+			remaining := candidates[:0]
+			for _, c := range candidates {
+				if c.Word != w.Word {
+					remaining = append(remaining, c)
+				} else {
+					total -= c.weight()
+				}
+			}
+			candidates = remaining
+		}
+	}
+
+	return picked
+}
+
+// pickWeighted returns the index of a candidate picked at random in
+// proportion to its weight, where total is the sum of their weights
+func pickWeighted(candidates []*Word, total float64, rng *rand.Rand) int {
+
+	r := rng.Float64() * total
+	for i, w := range candidates {
+		if r -= w.weight(); r < 0 {
+			return i
+		}
+	}
+
+	// Rounding can leave r just short of reaching zero
+	return len(candidates) - 1
+}
@@ -0,0 +1,171 @@
+package function
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// seededResponse returns the data, as JSON, of the response to an event of
+// eventType with the given id, seed extension, if any, and JSON data, with
+// words picked from embed://default
+func seededResponse(t *testing.T, eventType string, id string, seed interface{}, data string) string {
+
+	t.Helper()
+
+	source, err := newWordSource("embed://default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wordList, err := source.Words()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &CloudEvent{
+		Type:            eventType,
+		SpecVersion:     specVersion10,
+		Source:          "/mycontext",
+		ID:              id,
+		DataContentType: "application/json",
+		Data:            []byte(data),
+	}
+	if seed != nil {
+		c.Extensions = map[string]interface{}{seedExtension: seed}
+	}
+
+	m, err := routeEvent(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataVal, _, err := m.route.action(c, wordList, m, requestRand(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(dataVal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+const (
+	seededWordType     = "io.madlib.found.noun"
+	seededWordsData    = `{"count":3,"unique":true}`
+	seededTemplateType = "io.madlib.template.submitted"
+	seededTemplateData = `{"template":"The {{adjective}} {{noun}} likes to {{verb}} {{adverb}}"}`
+)
+
+// Test_Selection_Reproducible pins the words picked for given ids and seeds,
+// which contract tests may assert.  Should it fail, a change to how words are
+// picked has changed what the same request is answered with.
+func Test_Selection_Reproducible(t *testing.T) {
+
+	var tests = []struct {
+		title     string
+		id        string
+		seed      interface{}
+		words     string
+		templated string
+	}{
+		{
+			title:     "Seeded by id",
+			id:        "1234-1234-1234",
+			words:     `{"words":["teapot","zeppelin","igloo"],"count":3,"unique":true}`,
+			templated: `{"text":"The slimy zeppelin likes to hop sleepily","words":[{"type":"adjective","word":"slimy"},{"type":"noun","word":"zeppelin"},{"type":"verb","word":"hop"},{"type":"adverb","word":"sleepily"}]}`,
+		},
+		{
+			title:     "Integer seed",
+			id:        "1",
+			seed:      int32(42),
+			words:     `{"words":["jellyfish","balloon","pancake"],"count":3,"unique":true}`,
+			templated: `{"text":"The hairy balloon likes to nibble cheerfully","words":[{"type":"adjective","word":"hairy"},{"type":"noun","word":"balloon"},{"type":"verb","word":"nibble"},{"type":"adverb","word":"cheerfully"}]}`,
+		},
+		{
+			title:     "String seed",
+			id:        "1",
+			seed:      "banana",
+			words:     `{"words":["yo-yo","mushroom","walrus"],"count":3,"unique":true}`,
+			templated: `{"text":"The wobbly mushroom likes to wobble mysteriously","words":[{"type":"adjective","word":"wobbly"},{"type":"noun","word":"mushroom"},{"type":"verb","word":"wobble"},{"type":"adverb","word":"mysteriously"}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+
+			if got := seededResponse(t, seededWordType, test.id, test.seed, seededWordsData); got != test.words {
+				t.Errorf("words want: %s got: %s", test.words, got)
+			}
+			if got := seededResponse(t, seededTemplateType, test.id, test.seed, seededTemplateData); got != test.templated {
+				t.Errorf("template want: %s got: %s", test.templated, got)
+			}
+		})
+	}
+}
+
+// seededRequest is the id and seed extension, if any, of a request event
+type seededRequest struct {
+	id   string
+	seed interface{}
+}
+
+func Test_Selection_SameSeed(t *testing.T) {
+
+	var tests = []struct {
+		title string
+		a, b  seededRequest
+	}{
+		{"Same id", seededRequest{"abc", nil}, seededRequest{"abc", nil}},
+		{"Same integer seed, different ids", seededRequest{"a", "42"}, seededRequest{"b", "42"}},
+		{"Integer seed as a number or a string", seededRequest{"a", int32(42)}, seededRequest{"a", "42"}},
+		{"Same string seed, different ids", seededRequest{"a", "banana"}, seededRequest{"b", "banana"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+
+			a := seededResponse(t, seededWordType, test.a.id, test.a.seed, seededWordsData)
+			b := seededResponse(t, seededWordType, test.b.id, test.b.seed, seededWordsData)
+			if a != b {
+				t.Errorf("words differ: %s and %s", a, b)
+			}
+
+			a = seededResponse(t, seededTemplateType, test.a.id, test.a.seed, seededTemplateData)
+			b = seededResponse(t, seededTemplateType, test.b.id, test.b.seed, seededTemplateData)
+			if a != b {
+				t.Errorf("templates differ: %s and %s", a, b)
+			}
+		})
+	}
+}
+
+func Test_Selection_DifferentSeeds(t *testing.T) {
+
+	var tests = []struct {
+		title string
+		a, b  seededRequest
+	}{
+		{"Different ids", seededRequest{"abc", nil}, seededRequest{"abd", nil}},
+		{"Different integer seeds", seededRequest{"a", int32(42)}, seededRequest{"a", int32(43)}},
+		{"Different string seeds", seededRequest{"a", "banana"}, seededRequest{"a", "bandana"}},
+		{"Seed rather than id", seededRequest{"banana", nil}, seededRequest{"banana", "42"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+
+			a := seededResponse(t, seededWordType, test.a.id, test.a.seed, seededWordsData)
+			b := seededResponse(t, seededWordType, test.b.id, test.b.seed, seededWordsData)
+			if a == b {
+				t.Errorf("words the same: %s", a)
+			}
+
+			a = seededResponse(t, seededTemplateType, test.a.id, test.a.seed, seededTemplateData)
+			b = seededResponse(t, seededTemplateType, test.b.id, test.b.seed, seededTemplateData)
+			if a == b {
+				t.Errorf("templates the same: %s", a)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package function
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const shutdownGracePeriodEnvVar = "shutdownGracePeriod"
+
+func init() {
+
+	callbacks.resume()
+	go drainOnSignal(callbacks, envDuration(shutdownGracePeriodEnvVar, 10*time.Second))
+
+}
+
+// drainOnSignal shuts d down on SIGTERM or SIGINT, giving in-flight deliveries
+// up to grace to complete, then lets the signal take its usual course.  The
+// template's main exits on its own schedule and may not wait that long, so
+// it's pendingDir that keeps deliveries from being lost.
+func drainOnSignal(d *dispatcher, grace time.Duration) {
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	sig := <-signals
+	log.Printf("%s received, draining callbacks for up to %s", sig, grace)
+	d.shutdown(grace)
+
+	signal.Stop(signals)
+	if p, err := os.FindProcess(os.Getpid()); err == nil {
+		p.Signal(sig)
+	}
+}
+
+// shutdown stops d accepting deliveries and waits up to grace for those in
+// flight to complete.  Any still waiting to be retried, or still queued, are
+// left in pendingDir.
+func (d *dispatcher) shutdown(grace time.Duration) {
+
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(grace):
+		// Interrupt deliveries waiting to retry and give any attempts under
+		// way the time their requests are allowed to finish
+		d.stop.Do(func() { close(d.stopping) })
+		select {
+		case <-done:
+		case <-time.After(d.client.Timeout):
+		}
+	}
+
+	if pending, _ := filepath.Glob(filepath.Join(d.pendingDir, "*.json")); len(pending) > 0 {
+		log.Printf("%d callback(s) left in %s to be resumed on next start", len(pending), d.pendingDir)
+	}
+}
+
+// persist records dl in pendingDir until it completes
+func (d *dispatcher) persist(dl *delivery) {
+
+	if err := writeDelivery(d.pendingDir, dl); err != nil {
+		log.Printf("callback %s: unable to persist: %s", dl.ID, err)
+	}
+}
+
+// unpersist removes dl from pendingDir once it has completed
+func (d *dispatcher) unpersist(dl *delivery) {
+
+	err := os.Remove(filepath.Join(d.pendingDir, dl.ID+".json"))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("callback %s: unable to remove from %s: %s", dl.ID, d.pendingDir, err)
+	}
+}
+
+// resume queues the deliveries left in pendingDir by an earlier run
+func (d *dispatcher) resume() {
+
+	files, err := ioutil.ReadDir(d.pendingDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("unable to resume callbacks: %s", err)
+		}
+		return
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(d.pendingDir, f.Name())
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Printf("unable to resume callback %s: %s", f.Name(), err)
+			continue
+		}
+
+		dl := &delivery{}
+		if err := json.Unmarshal(b, dl); err != nil || len(dl.ID) == 0 {
+			log.Printf("unable to resume callback %s: not a delivery", f.Name())
+			continue
+		}
+		dl.Status = deliveryPending
+
+		if _, err := d.enqueue([]*delivery{dl}); err != nil {
+			log.Printf("unable to resume callback %s: %s", dl.ID, err)
+			continue
+		}
+		log.Printf("resumed callback %s to %s", dl.ID, dl.URL)
+	}
+}
@@ -0,0 +1,218 @@
+package function
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	callbackSigningKeysEnvVar     = "callbackSigningKeys"
+	callbackSigningKeysFileEnvVar = "callbackSigningKeysFile"
+
+	signatureHeader          = "X-Signature"
+	signatureKeyIDHeader     = "X-Signature-Key-Id"
+	signatureTimestampHeader = "X-Signature-Timestamp"
+	signatureScheme          = "sha256="
+)
+
+// signingKey is a shared secret callbacks are signed with
+type signingKey struct {
+	id     string
+	secret []byte
+}
+
+// keyRing holds the keys callbacks are signed with, the first of which is
+// used.  Keys come from a list of "id=secret" pairs and from a file of such
+// pairs, one per line, which is re-read whenever it changes so keys can be
+// rotated without a restart.
+type keyRing struct {
+	static []signingKey
+	path   string
+
+	mu      sync.Mutex
+	modTime time.Time
+	file    []signingKey
+}
+
+func newKeyRing(pairs []string, path string) *keyRing {
+
+	return &keyRing{
+		static: parseSigningKeys(pairs),
+		path:   path,
+	}
+}
+
+func parseSigningKeys(pairs []string) []signingKey {
+
+	var keys []signingKey
+	for _, pair := range pairs {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 || len(kv[1]) == 0 {
+			continue
+		}
+		keys = append(keys, signingKey{id: kv[0], secret: []byte(kv[1])})
+	}
+	return keys
+}
+
+// current returns the key to sign with, or nil when there are no keys
+func (r *keyRing) current() (*signingKey, error) {
+
+	if len(r.path) > 0 {
+		keys, err := r.load()
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) > 0 {
+			return &keys[0], nil
+		}
+	}
+
+	if len(r.static) > 0 {
+		return &r.static[0], nil
+	}
+	return nil, nil
+}
+
+// load returns the keys in the key file, reading it again if it has changed
+func (r *keyRing) load() ([]signingKey, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return nil, err
+	}
+	if info.ModTime().Equal(r.modTime) {
+		return r.file, nil
+	}
+
+	b, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pairs []string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); len(line) > 0 && !strings.HasPrefix(line, "#") {
+			pairs = append(pairs, line)
+		}
+	}
+
+	r.file, r.modTime = parseSigningKeys(pairs), info.ModTime()
+	return r.file, nil
+}
+
+// sign adds signature headers for header and body to header, leaving it
+// unsigned when there are no keys
+func (r *keyRing) sign(header http.Header, body []byte) error {
+
+	key, err := r.current()
+	if err != nil || key == nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	header.Set(signatureTimestampHeader, timestamp)
+	header.Set(signatureKeyIDHeader, key.id)
+	header.Set(signatureHeader, signatureScheme+hex.EncodeToString(computeSignature(key.secret, timestamp, header, body)))
+
+	return nil
+}
+
+// computeSignature returns the HMAC-SHA256 of the timestamp, a full stop, the
+// signed headers and the body
+func computeSignature(secret []byte, timestamp string, header http.Header, body []byte) []byte {
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(signedHeaders(header))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// signedHeaders returns Content-Type and the ce- headers, which carry the
+// attributes of binary mode events, as "name:value" lines sorted by lower
+// case name and followed by an empty line.  Header values can't hold a line
+// break, so the empty line marks where the headers end and the body begins.
+func signedHeaders(header http.Header) []byte {
+
+	values := make(map[string][]string)
+	for name, v := range header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "ce-") {
+			values[name] = append(values[name], v...)
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	for _, name := range names {
+		b.WriteString(name + ":" + strings.Join(values[name], ",") + "\n")
+	}
+	b.WriteString("\n")
+	return b.Bytes()
+}
+
+// ErrInvalidSignature is returned by VerifySignature when a callback was not
+// signed by any of the given keys
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// VerifySignature checks that a callback received from this function, with
+// the given header and body, was signed by one of secrets, keyed by key ID,
+// no more than tolerance ago.  Content-Type and the ce- headers are checked
+// along with the body, so the attributes of binary mode events can't be
+// changed either.
+func VerifySignature(header http.Header, body []byte, secrets map[string]string, tolerance time.Duration) error {
+
+	timestamp := header.Get(signatureTimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", signatureTimestampHeader, timestamp)
+	}
+
+	age := time.Since(time.Unix(seconds, 0))
+	if age > tolerance || age < -tolerance {
+		return fmt.Errorf("%s %s is outside the tolerance of %s", signatureTimestampHeader, timestamp, tolerance)
+	}
+
+	keyID := header.Get(signatureKeyIDHeader)
+	secret, ok := secrets[keyID]
+	if !ok {
+		return fmt.Errorf("unknown %s %q", signatureKeyIDHeader, keyID)
+	}
+
+	signature := header.Get(signatureHeader)
+	if !strings.HasPrefix(signature, signatureScheme) {
+		return ErrInvalidSignature
+	}
+
+	mac, err := hex.DecodeString(signature[len(signatureScheme):])
+	if err != nil || !hmac.Equal(mac, computeSignature([]byte(secret), timestamp, header, body)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,291 @@
+package function
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedCallback returns the header and body of a binary mode callback signed
+// with keys
+func signedCallback(t *testing.T, keys *keyRing) (http.Header, []byte) {
+
+	t.Helper()
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("ce-specversion", "1.0")
+	header.Set("ce-type", "io.madlib.picked.noun")
+	header.Set("ce-source", "/mycontext")
+	header.Set("ce-id", "1")
+	body := []byte(`{"word":"cat"}`)
+
+	if err := keys.sign(header, body); err != nil {
+		t.Fatal(err)
+	}
+	return header, body
+}
+
+func Test_Signature_ReferenceEncoding(t *testing.T) {
+
+	header := http.Header{
+		"Content-Type":   []string{"application/json"},
+		"Ce-Specversion": []string{"1.0"},
+		"Ce-Type":        []string{"io.madlib.picked.noun"},
+		"Ce-Source":      []string{"/mycontext"},
+		"Ce-Id":          []string{"1"},
+		"Accept":         []string{"*/*"},
+	}
+
+	// The HMAC-SHA256, keyed "secret", of 1556704800 and a full stop, the
+	// signed headers as lower case "name:value" lines in order and an empty
+	// line, then the body
+	want := "684cd4aa81f7c2a01e4ecae30edfe6af42da6308d202cbbeb93fb7bb3bc23498"
+
+	got := hex.EncodeToString(computeSignature([]byte("secret"), "1556704800", header, []byte(`{"word":"cat"}`)))
+	if got != want {
+		t.Errorf("signature want: %s got: %s", want, got)
+	}
+}
+
+func Test_Signature_Verify(t *testing.T) {
+
+	secrets := map[string]string{"k1": "old", "k2": "new"}
+
+	var tests = []struct {
+		title   string
+		keys    *keyRing
+		tamper  func(header http.Header, body []byte) []byte
+		secrets map[string]string
+		invalid string
+	}{
+		{
+			title: "Good signature",
+			keys:  newKeyRing([]string{"k1=old"}, ""),
+		},
+		{
+			title: "Signed with the first key",
+			keys:  newKeyRing([]string{"k2=new", "k1=old"}, ""),
+		},
+		{
+			title: "Tampered body",
+			keys:  newKeyRing([]string{"k1=old"}, ""),
+			tamper: func(header http.Header, body []byte) []byte {
+				return []byte(`{"word":"dog"}`)
+			},
+			invalid: "invalid signature",
+		},
+		{
+			title: "Tampered attribute",
+			keys:  newKeyRing([]string{"k1=old"}, ""),
+			tamper: func(header http.Header, body []byte) []byte {
+				header.Set("ce-source", "/elsewhere")
+				return body
+			},
+			invalid: "invalid signature",
+		},
+		{
+			title: "Added attribute",
+			keys:  newKeyRing([]string{"k1=old"}, ""),
+			tamper: func(header http.Header, body []byte) []byte {
+				header.Set("ce-subject", "forged")
+				return body
+			},
+			invalid: "invalid signature",
+		},
+		{
+			title: "Removed attribute",
+			keys:  newKeyRing([]string{"k1=old"}, ""),
+			tamper: func(header http.Header, body []byte) []byte {
+				header.Del("ce-id")
+				return body
+			},
+			invalid: "invalid signature",
+		},
+		{
+			title: "Tampered content type",
+			keys:  newKeyRing([]string{"k1=old"}, ""),
+			tamper: func(header http.Header, body []byte) []byte {
+				header.Set("Content-Type", "text/plain")
+				return body
+			},
+			invalid: "invalid signature",
+		},
+		{
+			title: "Unsigned header changed",
+			keys:  newKeyRing([]string{"k1=old"}, ""),
+			tamper: func(header http.Header, body []byte) []byte {
+				header.Set("User-Agent", "proxy")
+				return body
+			},
+		},
+		{
+			title: "Attribute moved into the body",
+			keys:  newKeyRing([]string{"k1=old"}, ""),
+			tamper: func(header http.Header, body []byte) []byte {
+				header.Del("ce-type")
+				return append([]byte("ce-type:io.madlib.picked.noun\n"), body...)
+			},
+			invalid: "invalid signature",
+		},
+		{
+			title:   "Signed with another secret",
+			keys:    newKeyRing([]string{"k1=guess"}, ""),
+			invalid: "invalid signature",
+		},
+		{
+			title:   "Unknown key ID",
+			keys:    newKeyRing([]string{"k3=other"}, ""),
+			invalid: `unknown X-Signature-Key-Id "k3"`,
+		},
+		{
+			title:   "Key ID no longer accepted",
+			keys:    newKeyRing([]string{"k1=old"}, ""),
+			secrets: map[string]string{"k2": "new"},
+			invalid: `unknown X-Signature-Key-Id "k1"`,
+		},
+		{
+			title: "Stale timestamp",
+			keys:  newKeyRing([]string{"k1=old"}, ""),
+			tamper: func(header http.Header, body []byte) []byte {
+				header.Set(signatureTimestampHeader, strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10))
+				return body
+			},
+			invalid: "outside the tolerance",
+		},
+		{
+			title: "Future timestamp",
+			keys:  newKeyRing([]string{"k1=old"}, ""),
+			tamper: func(header http.Header, body []byte) []byte {
+				header.Set(signatureTimestampHeader, strconv.FormatInt(time.Now().Add(10*time.Minute).Unix(), 10))
+				return body
+			},
+			invalid: "outside the tolerance",
+		},
+		{
+			title: "Invalid timestamp",
+			keys:  newKeyRing([]string{"k1=old"}, ""),
+			tamper: func(header http.Header, body []byte) []byte {
+				header.Set(signatureTimestampHeader, "yesterday")
+				return body
+			},
+			invalid: "invalid X-Signature-Timestamp",
+		},
+		{
+			title: "Other signature scheme",
+			keys:  newKeyRing([]string{"k1=old"}, ""),
+			tamper: func(header http.Header, body []byte) []byte {
+				header.Set(signatureHeader, "sha1="+header.Get(signatureHeader)[len(signatureScheme):])
+				return body
+			},
+			invalid: "invalid signature",
+		},
+		{
+			title: "Signature not hex",
+			keys:  newKeyRing([]string{"k1=old"}, ""),
+			tamper: func(header http.Header, body []byte) []byte {
+				header.Set(signatureHeader, signatureScheme+"zz")
+				return body
+			},
+			invalid: "invalid signature",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+
+			header, body := signedCallback(t, test.keys)
+			if test.tamper != nil {
+				body = test.tamper(header, body)
+			}
+
+			accepted := secrets
+			if test.secrets != nil {
+				accepted = test.secrets
+			}
+
+			err := VerifySignature(header, body, accepted, time.Minute)
+
+			switch {
+			case len(test.invalid) == 0 && err != nil:
+				t.Errorf("want valid, got: %s", err)
+			case len(test.invalid) > 0 && err == nil:
+				t.Errorf("want invalid")
+			case len(test.invalid) > 0 && !strings.Contains(err.Error(), test.invalid):
+				t.Errorf("want %s, got: %s", test.invalid, err)
+			}
+		})
+	}
+}
+
+func Test_Signature_Unsigned(t *testing.T) {
+
+	header, _ := signedCallback(t, newKeyRing(nil, ""))
+	if len(header.Get(signatureHeader)) > 0 {
+		t.Errorf("want no signature without keys, got: %s", header.Get(signatureHeader))
+	}
+}
+
+func Test_Signature_RotatedFileKey(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "signature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys")
+	if err := ioutil.WriteFile(path, []byte("# current first\nk1=old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file's keys are preferred to the static ones
+	keys := newKeyRing([]string{"k0=static"}, path)
+
+	header, body := signedCallback(t, keys)
+	if id := header.Get(signatureKeyIDHeader); id != "k1" {
+		t.Errorf("key ID want: k1 got: %s", id)
+	}
+	oldHeader, oldBody := header, body
+
+	// Rotate in a new key, making sure the change is seen whatever the
+	// resolution of the file system's modification times
+	if err := ioutil.WriteFile(path, []byte("k2=new\nk1=old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	header, body = signedCallback(t, keys)
+	if id := header.Get(signatureKeyIDHeader); id != "k2" {
+		t.Errorf("key ID after rotation want: k2 got: %s", id)
+	}
+
+	both := map[string]string{"k1": "old", "k2": "new"}
+	if err := VerifySignature(header, body, both, time.Minute); err != nil {
+		t.Errorf("signed with the new key: %s", err)
+	}
+	if err := VerifySignature(oldHeader, oldBody, both, time.Minute); err != nil {
+		t.Errorf("signed with the old key: %s", err)
+	}
+	if err := VerifySignature(header, body, map[string]string{"k1": "old"}, time.Minute); err == nil {
+		t.Errorf("signed with the new key, want refused by a receiver without it")
+	}
+
+	// Once the file is gone nothing is signed rather than falling back to
+	// the static keys
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.sign(http.Header{}, body); err == nil {
+		t.Errorf("key file removed, want an error")
+	}
+}
@@ -0,0 +1,328 @@
+package function
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	specVersion01 = "0.1"
+	specVersion02 = "0.2"
+	specVersion03 = "0.3"
+	specVersion10 = "1.0"
+
+	base64Encoding     = "base64"
+	relatedIDExtension = "relatedid"
+)
+
+// cloudEventV01 is the wire representation of a CloudEvent v0.1
+// https://github.com/cloudevents/spec/blob/v0.1/json-format.md
+type cloudEventV01 struct {
+	EventType          string                 `json:"eventType"`
+	EventTypeVersion   string                 `json:"eventTypeVersion,omitempty"`
+	CloudEventsVersion string                 `json:"cloudEventsVersion"`
+	Source             string                 `json:"source"`
+	EventID            string                 `json:"eventID"`
+	EventTime          string                 `json:"eventTime,omitempty"`
+	SchemaURL          string                 `json:"schemaURL,omitempty"`
+	ContentType        string                 `json:"contentType,omitempty"`
+	Extensions         map[string]interface{} `json:"extensions,omitempty"`
+	Data               json.RawMessage        `json:"data,omitempty"`
+}
+
+// cloudEventV02 is the wire representation of a CloudEvent v0.2
+// https://github.com/cloudevents/spec/blob/v0.2/json-format.md
+type cloudEventV02 struct {
+	Type             string          `json:"type"`
+	EventTypeVersion string          `json:"eventTypeVersion,omitempty"`
+	SpecVersion      string          `json:"specversion"`
+	Source           string          `json:"source"`
+	ID               string          `json:"id"`
+	Time             string          `json:"time,omitempty"`
+	SchemaURL        string          `json:"schemaurl,omitempty"`
+	RelatedID        string          `json:"relatedid,omitempty"`
+	ContentType      string          `json:"contenttype,omitempty"`
+	Data             json.RawMessage `json:"data,omitempty"`
+}
+
+// cloudEventV03 is the wire representation of a CloudEvent v0.3
+// https://github.com/cloudevents/spec/blob/v0.3/json-format.md
+type cloudEventV03 struct {
+	Type                string          `json:"type"`
+	SpecVersion         string          `json:"specversion"`
+	Source              string          `json:"source"`
+	ID                  string          `json:"id"`
+	Time                string          `json:"time,omitempty"`
+	Subject             string          `json:"subject,omitempty"`
+	SchemaURL           string          `json:"schemaurl,omitempty"`
+	RelatedID           string          `json:"relatedid,omitempty"`
+	DataContentType     string          `json:"datacontenttype,omitempty"`
+	DataContentEncoding string          `json:"datacontentencoding,omitempty"`
+	Data                json.RawMessage `json:"data,omitempty"`
+}
+
+// cloudEventV10 is the wire representation of a CloudEvent v1.0
+// https://github.com/cloudevents/spec/blob/v1.0/json-format.md
+type cloudEventV10 struct {
+	Type            string          `json:"type"`
+	SpecVersion     string          `json:"specversion"`
+	Source          string          `json:"source"`
+	ID              string          `json:"id"`
+	Time            string          `json:"time,omitempty"`
+	Subject         string          `json:"subject,omitempty"`
+	DataSchema      string          `json:"dataschema,omitempty"`
+	RelatedID       string          `json:"relatedid,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+}
+
+// specVersionProbe picks out the attributes which identify the spec version
+// of a structured event before it is fully decoded
+type specVersionProbe struct {
+	SpecVersion        string `json:"specversion" mapstructure:"specversion"`
+	CloudEventsVersion string `json:"cloudEventsVersion" mapstructure:"cloudeventsversion"`
+}
+
+func (p specVersionProbe) version() (string, error) {
+
+	version := p.SpecVersion
+	if len(version) == 0 {
+		version = p.CloudEventsVersion
+	}
+
+	switch version {
+	case specVersion01, specVersion02, specVersion03, specVersion10:
+		return version, nil
+	case "":
+		return "", fmt.Errorf("unable to determine CloudEvents spec version")
+	}
+	return "", fmt.Errorf("unsupported CloudEvents spec version: %s", version)
+}
+
+// newWireEvent returns an empty wire representation for the given spec version
+func newWireEvent(specVersion string) (interface{}, error) {
+
+	switch specVersion {
+	case specVersion01:
+		return &cloudEventV01{}, nil
+	case specVersion02:
+		return &cloudEventV02{}, nil
+	case specVersion03:
+		return &cloudEventV03{}, nil
+	case specVersion10:
+		return &cloudEventV10{}, nil
+	}
+	return nil, fmt.Errorf("unsupported CloudEvents spec version: %s", specVersion)
+}
+
+// fromWireEvent converts any of the versioned wire representations into a CloudEvent
+func fromWireEvent(w interface{}) (*CloudEvent, error) {
+
+	var c *CloudEvent
+
+	switch e := w.(type) {
+	case *cloudEventV01:
+		c = &CloudEvent{
+			SpecVersion:      specVersion01,
+			Type:             e.EventType,
+			EventTypeVersion: e.EventTypeVersion,
+			Source:           e.Source,
+			ID:               e.EventID,
+			rawTime:          e.EventTime,
+			DataSchema:       e.SchemaURL,
+			RelatedID:        extensionString(e.Extensions[relatedIDExtension]),
+			DataContentType:  e.ContentType,
+			Extensions:       withoutExtension(e.Extensions, relatedIDExtension),
+			Data:             decodeJSONData(e.Data, e.ContentType),
+		}
+	case *cloudEventV02:
+		c = &CloudEvent{
+			SpecVersion:      specVersion02,
+			Type:             e.Type,
+			EventTypeVersion: e.EventTypeVersion,
+			Source:           e.Source,
+			ID:               e.ID,
+			rawTime:          e.Time,
+			DataSchema:       e.SchemaURL,
+			RelatedID:        e.RelatedID,
+			DataContentType:  e.ContentType,
+			Data:             decodeJSONData(e.Data, e.ContentType),
+		}
+	case *cloudEventV03:
+		c = &CloudEvent{
+			SpecVersion:     specVersion03,
+			Type:            e.Type,
+			Source:          e.Source,
+			ID:              e.ID,
+			rawTime:         e.Time,
+			Subject:         e.Subject,
+			DataSchema:      e.SchemaURL,
+			RelatedID:       e.RelatedID,
+			DataContentType: e.DataContentType,
+			Data:            decodeJSONData(e.Data, e.DataContentType),
+		}
+		if e.DataContentEncoding == base64Encoding && len(e.Data) > 0 {
+			var encoded string
+			if err := json.Unmarshal(e.Data, &encoded); err != nil {
+				return nil, err
+			}
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, err
+			}
+			c.Data = decoded
+		}
+	case *cloudEventV10:
+		c = &CloudEvent{
+			SpecVersion:     specVersion10,
+			Type:            e.Type,
+			Source:          e.Source,
+			ID:              e.ID,
+			rawTime:         e.Time,
+			Subject:         e.Subject,
+			DataSchema:      e.DataSchema,
+			RelatedID:       e.RelatedID,
+			DataContentType: e.DataContentType,
+			Data:            decodeJSONData(e.Data, e.DataContentType),
+		}
+		if len(e.DataBase64) > 0 {
+			c.Data = e.DataBase64
+		}
+	default:
+		return nil, fmt.Errorf("unsupported wire event type: %T", w)
+	}
+
+	if len(c.rawTime) > 0 {
+		c.Time, _ = time.Parse(time.RFC3339, c.rawTime)
+	}
+
+	return c, nil
+}
+
+// toWireEvent converts a CloudEvent into the wire representation for its spec version
+func toWireEvent(c *CloudEvent) (interface{}, error) {
+
+	// v0.1 and v0.2 have no encoding for data which isn't JSON, so it is
+	// carried as a JSON string
+	data, isJSON := jsonData(c)
+	if !isJSON && len(c.Data) > 0 && (c.SpecVersion == specVersion01 || c.SpecVersion == specVersion02) {
+		var err error
+		if data, err = stringData(c); err != nil {
+			return nil, err
+		}
+	}
+
+	switch c.SpecVersion {
+	case specVersion01:
+		return &cloudEventV01{
+			EventType:          c.Type,
+			EventTypeVersion:   c.EventTypeVersion,
+			CloudEventsVersion: c.SpecVersion,
+			Source:             c.Source,
+			EventID:            c.ID,
+			EventTime:          formatTime(c.Time),
+			SchemaURL:          c.DataSchema,
+			ContentType:        c.DataContentType,
+			Extensions:         withExtension(c.Extensions, relatedIDExtension, c.RelatedID),
+			Data:               data,
+		}, nil
+	case specVersion02:
+		return &cloudEventV02{
+			Type:             c.Type,
+			EventTypeVersion: c.EventTypeVersion,
+			SpecVersion:      c.SpecVersion,
+			Source:           c.Source,
+			ID:               c.ID,
+			Time:             formatTime(c.Time),
+			SchemaURL:        c.DataSchema,
+			RelatedID:        c.RelatedID,
+			ContentType:      c.DataContentType,
+			Data:             data,
+		}, nil
+	case specVersion03:
+		e := &cloudEventV03{
+			Type:            c.Type,
+			SpecVersion:     c.SpecVersion,
+			Source:          c.Source,
+			ID:              c.ID,
+			Time:            formatTime(c.Time),
+			Subject:         c.Subject,
+			SchemaURL:       c.DataSchema,
+			RelatedID:       c.RelatedID,
+			DataContentType: c.DataContentType,
+			Data:            data,
+		}
+		if !isJSON && len(c.Data) > 0 {
+			encoded, err := json.Marshal(base64.StdEncoding.EncodeToString(c.Data))
+			if err != nil {
+				return nil, err
+			}
+			e.Data, e.DataContentEncoding = encoded, base64Encoding
+		}
+		return e, nil
+	case specVersion10:
+		e := &cloudEventV10{
+			Type:            c.Type,
+			SpecVersion:     c.SpecVersion,
+			Source:          c.Source,
+			ID:              c.ID,
+			Time:            formatTime(c.Time),
+			Subject:         c.Subject,
+			DataSchema:      c.DataSchema,
+			RelatedID:       c.RelatedID,
+			DataContentType: c.DataContentType,
+			Data:            data,
+		}
+		if !isJSON && len(c.Data) > 0 {
+			e.DataBase64 = c.Data
+		}
+		return e, nil
+	}
+	return nil, fmt.Errorf("unsupported CloudEvents spec version: %s", c.SpecVersion)
+}
+
+func formatTime(t time.Time) string {
+
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// withExtension returns a copy of extensions with name set to value
+func withExtension(extensions map[string]interface{}, name, value string) map[string]interface{} {
+
+	if len(value) == 0 {
+		return extensions
+	}
+
+	ret := map[string]interface{}{name: value}
+	for k, v := range extensions {
+		if k != name {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+// withoutExtension returns a copy of extensions with name removed
+func withoutExtension(extensions map[string]interface{}, name string) map[string]interface{} {
+
+	if _, ok := extensions[name]; !ok {
+		return extensions
+	}
+
+	ret := make(map[string]interface{})
+	for k, v := range extensions {
+		if k != name {
+			ret[k] = v
+		}
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
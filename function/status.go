@@ -0,0 +1,64 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/openfaas-incubator/go-function-sdk"
+)
+
+const deliveryQueryParam = "delivery"
+
+// deliveryLocation is the location of the status of the delivery with the
+// given ID, relative to the function
+func deliveryLocation(id string) string {
+	return "?" + url.Values{deliveryQueryParam: []string{id}}.Encode()
+}
+
+// sendDeliveryStatus returns a response carrying status, a deliveryStatus or
+// deliveryReport with the given ID, as a JSON body
+func sendDeliveryStatus(statusCode int, id string, status interface{}) (handler.Response, error) {
+
+	bMessage, err := json.Marshal(status)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	return handler.Response{
+		Body:       bMessage,
+		StatusCode: statusCode,
+		Header: map[string][]string{
+			"Content-Type": []string{"application/json"},
+			"Location":     []string{deliveryLocation(id)},
+		},
+	}, nil
+}
+
+// handleDeliveryStatus reports on the callback deliveries of a response event,
+// or on a single delivery, named by the delivery query parameter.  Only this
+// replica's deliveries are known, so with several replicas the status of a
+// delivery accepted by another is reported as not found.
+func handleDeliveryStatus(req *handler.Request) (handler.Response, error) {
+
+	query, err := url.ParseQuery(req.QueryString)
+	if err != nil {
+		return sendProblem(http.StatusBadRequest, err)
+	}
+
+	id := query.Get(deliveryQueryParam)
+	if len(id) == 0 {
+		return sendProblem(http.StatusBadRequest, fmt.Errorf("the %s query parameter is required", deliveryQueryParam))
+	}
+
+	if report, ok := callbacks.report(id); ok {
+		return sendDeliveryStatus(http.StatusOK, id, report)
+	}
+
+	if status, ok := callbacks.status(id); ok {
+		return sendDeliveryStatus(http.StatusOK, id, status)
+	}
+
+	return sendProblem(http.StatusNotFound, fmt.Errorf("no delivery %s", id))
+}
@@ -0,0 +1,110 @@
+package function
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var (
+	errNoTemplate = errors.New("the request carries no template")
+
+	// placeholderPattern matches a {{wordtype}} placeholder, which may pad
+	// the word type with spaces
+	placeholderPattern = regexp.MustCompile(`{{\s*([A-Za-z0-9_-]+)\s*}}`)
+)
+
+// wordChoice is the word picked for one of a template's placeholders
+type wordChoice struct {
+	Type string `json:"type"`
+	Word string `json:"word"`
+}
+
+// renderedTemplate is the data of a template.rendered event
+type renderedTemplate struct {
+	Text  string       `json:"text"`
+	Words []wordChoice `json:"words"`
+}
+
+// extractTemplate returns the template carried by c, either as text data, a
+// JSON string or the template member of a JSON object
+func extractTemplate(c *CloudEvent) (string, error) {
+
+	var template string
+
+	if raw, isJSON := jsonData(c); isJSON {
+		var data struct {
+			Template string `json:"template"`
+		}
+		if err := json.Unmarshal(raw, &template); err != nil {
+			if err := json.Unmarshal(raw, &data); err != nil {
+				return "", fmt.Errorf("invalid template: %s", err)
+			}
+			template = data.Template
+		}
+	} else if len(c.Data) > 0 && isTextContentType(c.DataContentType) {
+		template = string(c.Data)
+	}
+
+	if len(strings.TrimSpace(template)) == 0 {
+		return "", errNoTemplate
+	}
+	return template, nil
+}
+
+// renderTemplate fills each placeholder of the template carried by c with a
+// word of its type picked from wordList, returning the data of the
+// template.rendered response.  Tags and locale narrow the words picked as for
+// a single word, and unique templates never repeat a word.
+func renderTemplate(c *CloudEvent, wordList WordList, m *routeMatch, rng *rand.Rand) (interface{}, int, error) {
+
+	template, err := extractTemplate(c)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	selection, err := extractWordSelection(c)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	selection.Count = 1
+
+	placeholders := placeholderPattern.FindAllStringSubmatchIndex(template, -1)
+	if maxCount := envInt(maxWordCountEnvVar, defaultMaxWordCount); len(placeholders) > maxCount {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid template: %d placeholders, at most %d allowed", len(placeholders), maxCount)
+	}
+
+	var (
+		text strings.Builder
+		last int
+	)
+
+	rendered := &renderedTemplate{Words: make([]wordChoice, 0, len(placeholders))}
+
+	for _, p := range placeholders {
+		wordType := template[p[2]:p[3]]
+
+		picked := pickWords(wordList[wordType], selection, rng)
+		if len(picked) == 0 {
+			return nil, http.StatusNotFound, fmt.Errorf("no words available of type %s", selection.describe(wordType))
+		}
+
+		if selection.Unique {
+			selection.Exclude = append(selection.Exclude, picked[0])
+		}
+
+		text.WriteString(template[last:p[0]])
+		text.WriteString(picked[0])
+		last = p[1]
+
+		rendered.Words = append(rendered.Words, wordChoice{Type: wordType, Word: picked[0]})
+	}
+	text.WriteString(template[last:])
+
+	rendered.Text = text.String()
+	return rendered, http.StatusOK, nil
+}
@@ -0,0 +1,179 @@
+package function
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+const targetSpecVersionEnvVar = "targetSpecVersion"
+
+// attributeMapping describes a single context attribute and the name it goes by
+// in each spec version.  A version missing from names has no such attribute, so
+// the value is carried as an extension called name instead.
+type attributeMapping struct {
+	name  string
+	names map[string]string
+	get   func(c *CloudEvent) string
+	set   func(c *CloudEvent, v string)
+}
+
+// attributeMappings is the translation table between spec versions
+var attributeMappings = []attributeMapping{
+	{
+		name:  "id",
+		names: map[string]string{specVersion01: "eventID", specVersion02: "id", specVersion03: "id", specVersion10: "id"},
+		get:   func(c *CloudEvent) string { return c.ID },
+		set:   func(c *CloudEvent, v string) { c.ID = v },
+	},
+	{
+		name:  "source",
+		names: map[string]string{specVersion01: "source", specVersion02: "source", specVersion03: "source", specVersion10: "source"},
+		get:   func(c *CloudEvent) string { return c.Source },
+		set:   func(c *CloudEvent, v string) { c.Source = v },
+	},
+	{
+		name:  "specversion",
+		names: map[string]string{specVersion01: "cloudEventsVersion", specVersion02: "specversion", specVersion03: "specversion", specVersion10: "specversion"},
+		get:   func(c *CloudEvent) string { return c.SpecVersion },
+		set:   func(c *CloudEvent, v string) { c.SpecVersion = v },
+	},
+	{
+		name:  "type",
+		names: map[string]string{specVersion01: "eventType", specVersion02: "type", specVersion03: "type", specVersion10: "type"},
+		get:   func(c *CloudEvent) string { return c.Type },
+		set:   func(c *CloudEvent, v string) { c.Type = v },
+	},
+	{
+		name:  "time",
+		names: map[string]string{specVersion01: "eventTime", specVersion02: "time", specVersion03: "time", specVersion10: "time"},
+		get:   func(c *CloudEvent) string { return formatTime(c.Time) },
+		set:   func(c *CloudEvent, v string) { c.Time, _ = time.Parse(time.RFC3339, v) },
+	},
+	{
+		name:  "dataschema",
+		names: map[string]string{specVersion01: "schemaURL", specVersion02: "schemaurl", specVersion03: "schemaurl", specVersion10: "dataschema"},
+		get:   func(c *CloudEvent) string { return c.DataSchema },
+		set:   func(c *CloudEvent, v string) { c.DataSchema = v },
+	},
+	{
+		name:  "datacontenttype",
+		names: map[string]string{specVersion01: "contentType", specVersion02: "contenttype", specVersion03: "datacontenttype", specVersion10: "datacontenttype"},
+		get:   func(c *CloudEvent) string { return c.DataContentType },
+		set:   func(c *CloudEvent, v string) { c.DataContentType = v },
+	},
+	{
+		name:  "subject",
+		names: map[string]string{specVersion03: "subject", specVersion10: "subject"},
+		get:   func(c *CloudEvent) string { return c.Subject },
+		set:   func(c *CloudEvent, v string) { c.Subject = v },
+	},
+	{
+		name:  "eventtypeversion",
+		names: map[string]string{specVersion01: "eventTypeVersion", specVersion02: "eventTypeVersion"},
+		get:   func(c *CloudEvent) string { return c.EventTypeVersion },
+		set:   func(c *CloudEvent, v string) { c.EventTypeVersion = v },
+	},
+	{
+		name:  relatedIDExtension,
+		names: map[string]string{specVersion02: "relatedid", specVersion03: "relatedid", specVersion10: "relatedid"},
+		get:   func(c *CloudEvent) string { return c.RelatedID },
+		set:   func(c *CloudEvent, v string) { c.RelatedID = v },
+	},
+}
+
+// getTargetSpecVersion returns the spec version responses should be sent in,
+// defaulting to the version of the request when none is configured
+func getTargetSpecVersion(requestVersion string) (string, error) {
+
+	target := os.Getenv(targetSpecVersionEnvVar)
+
+	if len(target) == 0 {
+		return requestVersion, nil
+	}
+
+	return specVersionProbe{SpecVersion: target}.version()
+}
+
+// translateCloudEvent returns a copy of c expressed in the given spec version.
+// Attributes which the target version doesn't define are moved into extensions,
+// and extensions which the target version defines are promoted to attributes.
+func translateCloudEvent(c *CloudEvent, specVersion string) (*CloudEvent, error) {
+
+	if _, err := newWireEvent(specVersion); err != nil {
+		return nil, err
+	}
+
+	t := *c
+	t.SpecVersion = specVersion
+	t.Extensions = make(map[string]interface{})
+	for k, v := range c.Extensions {
+		t.Extensions[k] = v
+	}
+
+	for _, m := range attributeMappings {
+
+		if _, ok := m.names[specVersion]; !ok {
+			if v := m.get(&t); len(v) > 0 {
+				t.Extensions[m.name] = v
+				m.set(&t, "")
+			}
+			continue
+		}
+
+		if v, ok := t.Extensions[m.name]; ok {
+			if len(m.get(&t)) == 0 {
+				m.set(&t, extensionString(v))
+			}
+			delete(t.Extensions, m.name)
+		}
+	}
+
+	if len(t.Extensions) == 0 {
+		t.Extensions = nil
+	}
+
+	return &t, nil
+}
+
+// isContextAttribute reports whether name, lower-cased, is a context attribute
+// of the given spec version
+func isContextAttribute(specVersion, name string) bool {
+
+	for _, m := range attributeMappings {
+		if n, ok := m.names[specVersion]; ok && strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// binaryHeaders returns the ce- header names, less the prefix, and values used
+// to carry the attributes and extensions of c in its spec version.  The data
+// content type travels in the Content-Type header rather than as a ce- header.
+func binaryHeaders(c *CloudEvent) map[string]string {
+
+	headers := make(map[string]string)
+
+	for _, m := range attributeMappings {
+
+		name, ok := m.names[c.SpecVersion]
+		if !ok || m.name == "datacontenttype" {
+			continue
+		}
+
+		if v := m.get(c); len(v) > 0 {
+			headers[strings.ToLower(name)] = v
+		}
+	}
+
+	for name, v := range c.Extensions {
+
+		if c.SpecVersion == specVersion01 {
+			name = binaryExtensionPrefixV01 + name
+		}
+		headers[strings.ToLower(name)] = extensionString(v)
+	}
+
+	return headers
+}
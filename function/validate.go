@@ -0,0 +1,146 @@
+package function
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// v0.2 onwards restricts attribute names to lower-case alphanumerics
+	attributeNamePattern = regexp.MustCompile(`^[a-z0-9]+$`)
+	// v0.1 extensions are conventionally camelCased
+	attributeNamePatternV01 = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+)
+
+// Violation describes a single way in which an event breaks the spec
+type Violation struct {
+	Attribute string `json:"attribute"`
+	Reason    string `json:"reason"`
+}
+
+// ValidationError is returned when an event breaks the spec, listing every
+// violation found rather than only the first
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (v *ValidationError) Error() string {
+
+	reasons := make([]string, len(v.Violations))
+	for i, violation := range v.Violations {
+		reasons[i] = fmt.Sprintf("%s: %s", violation.Attribute, violation.Reason)
+	}
+	return "invalid CloudEvent: " + strings.Join(reasons, "; ")
+}
+
+func (v *ValidationError) add(attribute, format string, args ...interface{}) {
+	v.Violations = append(v.Violations, Violation{Attribute: attribute, Reason: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks c against the rules of its spec version, returning a
+// *ValidationError listing every violation, or nil when c is valid
+func (c *CloudEvent) Validate() error {
+
+	v := &ValidationError{}
+
+	if _, err := (specVersionProbe{SpecVersion: c.SpecVersion}).version(); err != nil {
+		v.add("specversion", "%s", err)
+	}
+
+	if len(c.ID) == 0 {
+		v.add("id", "required attribute is missing or empty")
+	}
+
+	if len(c.Type) == 0 {
+		v.add("type", "required attribute is missing or empty")
+	}
+
+	if len(c.Source) == 0 {
+		v.add("source", "required attribute is missing or empty")
+	} else if _, err := parseURIReference(c.Source); err != nil {
+		v.add("source", "must be a URI-reference: %s", err)
+	}
+
+	if len(c.rawTime) > 0 {
+		if _, err := time.Parse(time.RFC3339, c.rawTime); err != nil {
+			v.add("time", "must be an RFC3339 timestamp: %s", c.rawTime)
+		}
+	}
+
+	if len(c.DataSchema) > 0 {
+		if u, err := parseURIReference(c.DataSchema); err != nil || !u.IsAbs() {
+			v.add("dataschema", "must be an absolute URI: %s", c.DataSchema)
+		}
+	}
+
+	if len(c.DataContentType) > 0 {
+		if _, _, err := mime.ParseMediaType(c.DataContentType); err != nil {
+			v.add("datacontenttype", "must be an RFC 2046 media type: %s", err)
+		}
+	}
+
+	namePattern, nameRule := attributeNamePattern, "lower-case alphanumeric characters"
+	if c.SpecVersion == specVersion01 {
+		namePattern, nameRule = attributeNamePatternV01, "alphanumeric characters"
+	}
+
+	names := make([]string, 0, len(c.Extensions))
+	for name := range c.Extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !namePattern.MatchString(name) {
+			v.add(name, "extension attribute names must consist of %s", nameRule)
+		}
+		if !isExtensionValue(c.Extensions[name]) {
+			v.add(name, "extension attribute values must be a string, integer, boolean, URI, timestamp or binary")
+		}
+	}
+
+	if len(v.Violations) > 0 {
+		return v
+	}
+	return nil
+}
+
+// parseURIReference parses s as an RFC 3986 URI-reference.  url.Parse is far
+// more lenient than the RFC, accepting spaces and other characters a URI may
+// not hold, so those are checked for first.
+// https://tools.ietf.org/html/rfc3986#section-4.1
+func parseURIReference(s string) (*url.URL, error) {
+
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case b == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return nil, fmt.Errorf("invalid percent-encoding at offset %d", i)
+			}
+			i += 2
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9',
+			strings.IndexByte("-._~:/?#[]@!$&'()*+,;=", b) >= 0:
+		default:
+			r, _ := utf8.DecodeRuneInString(s[i:])
+			return nil, fmt.Errorf("invalid character %q at offset %d", r, i)
+		}
+	}
+
+	return url.Parse(s)
+}
+
+func isHex(b byte) bool {
+	return '0' <= b && b <= '9' || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F'
+}
+
+// specVersionViolation reports an event whose spec version can't be
+// determined, and so can't be decoded any further
+func specVersionViolation(err error) error {
+	return &ValidationError{Violations: []Violation{{Attribute: "specversion", Reason: err.Error()}}}
+}
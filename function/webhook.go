@@ -0,0 +1,231 @@
+package function
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openfaas-incubator/go-function-sdk"
+)
+
+// Abuse protection for webhooks
+// https://github.com/cloudevents/spec/blob/v1.0/http-webhook.md#4-abuse-protection
+const (
+	webhookRequestOriginHeader = "WebHook-Request-Origin"
+	webhookAllowedOriginHeader = "WebHook-Allowed-Origin"
+	webhookAllowedRateHeader   = "WebHook-Allowed-Rate"
+
+	webhookValidationEnvVar     = "webhookValidation"
+	webhookOriginEnvVar         = "webhookOrigin"
+	webhookPermissionTTLEnvVar  = "webhookPermissionTTL"
+	webhookAllowedOriginsEnvVar = "webhookAllowedOrigins"
+	webhookAllowedRateEnvVar    = "webhookAllowedRate"
+)
+
+// webhookPermission is a callback target's permission for us to send it
+// events, no more often than every interval when that is set
+type webhookPermission struct {
+	expires  time.Time
+	interval time.Duration
+	next     time.Time
+}
+
+// webhookValidator performs the validation handshake with callback targets
+// before events are sent to them, caching the permission granted for ttl and
+// spacing deliveries to each target at the rate it allows.  Targets are told
+// apart by origin, their scheme, host and port, so varying the path or query
+// doesn't make a new one.  handshakes holds a channel, closed once done, for
+// each handshake under way.
+type webhookValidator struct {
+	enabled bool
+	origin  string
+	ttl     time.Duration
+
+	mu          sync.Mutex
+	permissions map[string]*webhookPermission
+	handshakes  map[string]chan struct{}
+}
+
+func newWebhookValidator() *webhookValidator {
+
+	return &webhookValidator{
+		enabled:     envString(webhookValidationEnvVar, "true") != "false",
+		origin:      envString(webhookOriginEnvVar, "rgee0.o6s.io"),
+		ttl:         envDuration(webhookPermissionTTLEnvVar, 24*time.Hour),
+		permissions: make(map[string]*webhookPermission),
+		handshakes:  make(map[string]chan struct{}),
+	}
+}
+
+// permit returns how long to wait before asking again to send an event to
+// target, or 0 when it may be sent now, first asking target for permission if
+// we don't already have it.  Only an event sent now takes the target's next
+// slot, so those waiting don't queue up slots ahead of time.
+func (v *webhookValidator) permit(client *http.Client, target string) (time.Duration, *attemptError) {
+
+	if !v.enabled {
+		return 0, nil
+	}
+
+	p, err := v.permission(client, target)
+	if err != nil {
+		return 0, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(p.next) {
+		return p.next.Sub(now), nil
+	}
+	p.next = now.Add(p.interval)
+
+	return 0, nil
+}
+
+// permission returns target's current permission, asking for it when there
+// is none.  Only one handshake is made with a target at a time.
+func (v *webhookValidator) permission(client *http.Client, target string) (*webhookPermission, *attemptError) {
+
+	key, err := webhookTarget(target)
+	if err != nil {
+		return nil, &attemptError{msg: err.Error()}
+	}
+
+	for {
+		v.mu.Lock()
+		v.expire()
+
+		if p, ok := v.permissions[key]; ok {
+			v.mu.Unlock()
+			return p, nil
+		}
+
+		done, busy := v.handshakes[key]
+		if !busy {
+			done = make(chan struct{})
+			v.handshakes[key] = done
+		}
+		v.mu.Unlock()
+
+		// Wait for the handshake under way, then look again
+		if busy {
+			<-done
+			continue
+		}
+
+		p, aErr := v.handshake(client, target)
+
+		v.mu.Lock()
+		if aErr == nil {
+			v.permissions[key] = p
+		}
+		delete(v.handshakes, key)
+		close(done)
+		v.mu.Unlock()
+
+		return p, aErr
+	}
+}
+
+// expire forgets permissions once they have expired.  The caller must hold
+// v.mu.
+func (v *webhookValidator) expire() {
+
+	now := time.Now()
+	for key, p := range v.permissions {
+		if !now.Before(p.expires) {
+			delete(v.permissions, key)
+		}
+	}
+}
+
+// webhookTarget returns the origin of target, which its permission is kept
+// under
+func webhookTarget(target string) (string, error) {
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), nil
+}
+
+// handshake sends target a validation request, returning the permission it
+// grants
+func (v *webhookValidator) handshake(client *http.Client, target string) (*webhookPermission, *attemptError) {
+
+	req, err := http.NewRequest(http.MethodOptions, target, nil)
+	if err != nil {
+		return nil, &attemptError{msg: err.Error()}
+	}
+	req.Header.Set(webhookRequestOriginHeader, v.origin)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, &attemptError{msg: "webhook validation: " + err.Error(), retry: true}
+	}
+
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return nil, &attemptError{
+			msg:        "webhook validation: " + res.Status,
+			retry:      true,
+			retryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
+	case res.StatusCode < 200 || res.StatusCode >= 300:
+		return nil, &attemptError{msg: "webhook validation: " + res.Status}
+	}
+
+	allowed := res.Header.Get(webhookAllowedOriginHeader)
+	if allowed != "*" && !strings.EqualFold(allowed, v.origin) {
+		return nil, &attemptError{msg: fmt.Sprintf("webhook validation: origin %s is not allowed", v.origin)}
+	}
+
+	p := &webhookPermission{expires: time.Now().Add(v.ttl)}
+
+	// The rate is in requests per minute
+	if rate, err := strconv.Atoi(res.Header.Get(webhookAllowedRateHeader)); err == nil && rate > 0 {
+		p.interval = time.Minute / time.Duration(rate)
+	}
+
+	return p, nil
+}
+
+// handleWebhookValidation answers a validation request from a sender wanting
+// to use the function as a webhook
+func handleWebhookValidation(req *handler.Request) (handler.Response, error) {
+
+	origin := http.Header(req.Header).Get(webhookRequestOriginHeader)
+	if len(origin) == 0 {
+		return sendProblem(http.StatusBadRequest, fmt.Errorf("the %s header is required", webhookRequestOriginHeader))
+	}
+
+	allowedOrigins := envList(webhookAllowedOriginsEnvVar, []string{"*"})
+	if !containsFold(allowedOrigins, "*") && !containsFold(allowedOrigins, origin) {
+		return sendProblem(http.StatusForbidden, fmt.Errorf("origin %s is not allowed", origin))
+	}
+
+	headerVals := map[string][]string{
+		"Allow":                    []string{"GET, POST, OPTIONS"},
+		webhookAllowedOriginHeader: []string{origin},
+	}
+	if rate := envString(webhookAllowedRateEnvVar, ""); len(rate) > 0 {
+		headerVals[webhookAllowedRateHeader] = []string{rate}
+	}
+
+	return handler.Response{
+		StatusCode: http.StatusOK,
+		Header:     headerVals,
+	}, nil
+}
@@ -0,0 +1,136 @@
+package function
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/docker/distribution/uuid"
+)
+
+const (
+	wordListUpdatedEventType = "io.madlib.wordlist.updated"
+	wordsUpdatedSinkEnvVar   = "wordsUpdatedSink"
+)
+
+// wordTypeChange counts the words of a type after an update, and how many
+// were added and removed
+type wordTypeChange struct {
+	Words   int `json:"words"`
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// wordListChanges compares each type of the old and new word lists
+func wordListChanges(old WordList, new WordList) map[string]wordTypeChange {
+
+	changes := make(map[string]wordTypeChange)
+
+	for wordType := range old {
+		changes[wordType] = wordTypeChange{}
+	}
+
+	for wordType, wordList := range new {
+		change := wordTypeChange{Words: len(wordList)}
+		change.Added, change.Removed = diffWords(old[wordType], wordList)
+		changes[wordType] = change
+	}
+
+	for wordType, wordList := range old {
+		if _, ok := new[wordType]; !ok {
+			changes[wordType] = wordTypeChange{Removed: len(wordList)}
+		}
+	}
+
+	return changes
+}
+
+// diffWords counts the words in new but not old, and in old but not new
+func diffWords(old []Word, new []Word) (int, int) {
+
+	before := make(map[string]bool, len(old))
+	for _, w := range old {
+		before[w.Word] = true
+	}
+
+	after := make(map[string]bool, len(new))
+	added := 0
+	for _, w := range new {
+		after[w.Word] = true
+		if !before[w.Word] {
+			added++
+		}
+	}
+
+	removed := 0
+	for w := range before {
+		if !after[w] {
+			removed++
+		}
+	}
+
+	return added, removed
+}
+
+// initWordListUpdatedEvent returns an event announcing that the word list has
+// changed, with what changed in each type as its data
+func initWordListUpdatedEvent(old WordList, new WordList) *CloudEvent {
+
+	dataField, err := json.Marshal(map[string]interface{}{
+		"types": wordListChanges(old, new),
+	})
+	if err != nil {
+		dataField = nil
+	}
+
+	return &CloudEvent{
+		Type:            wordListUpdatedEventType,
+		SpecVersion:     specVersion10,
+		Source:          fnSource,
+		ID:              uuid.Generate().String(),
+		Time:            time.Now(),
+		DataContentType: "application/json",
+		Data:            dataField,
+	}
+}
+
+// sendWordListUpdated sends a wordlist.updated event to wordsUpdatedSink, if
+// set, as a callback.  The sink is set by the operator, typically to a service
+// in the cluster, so it's trusted rather than held to the callback URL policy.
+func sendWordListUpdated(old WordList, new WordList) {
+
+	sink := os.Getenv(wordsUpdatedSinkEnvVar)
+	if len(sink) == 0 {
+		log.Printf("word list updated")
+		return
+	}
+
+	if u, err := url.Parse(sink); err != nil || !u.IsAbs() || len(u.Host) == 0 {
+		log.Printf("not sending %s: %s %q is not an absolute URL", wordListUpdatedEventType, wordsUpdatedSinkEnvVar, sink)
+		return
+	}
+
+	format, _, err := getEventFormat(jsonMediaType)
+	if err != nil {
+		log.Printf("not sending %s: %s", wordListUpdatedEventType, err)
+		return
+	}
+
+	bMessage, headerVals, err := setStructuredCloudEvent(initWordListUpdatedEvent(old, new), format)
+	if err != nil {
+		log.Printf("not sending %s: %s", wordListUpdatedEventType, err)
+		return
+	}
+
+	dl := newDelivery(sink, bMessage, headerVals)
+	dl.Trusted = true
+
+	report, err := callbacks.enqueue([]*delivery{dl})
+	if err != nil {
+		log.Printf("not sending %s: %s", wordListUpdatedEventType, err)
+		return
+	}
+	log.Printf("word list updated, sending %s to %s as delivery %s", wordListUpdatedEventType, sink, report.ID)
+}
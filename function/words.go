@@ -2,51 +2,163 @@ package function
 
 import (
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
 	"log"
-	"math/rand"
-	"net/http"
-	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func getWordList() map[string][]string {
+const (
+	wordsRetryInitialBackoffEnvVar = "wordsRetryInitialBackoff"
+	wordsRetryMaxBackoffEnvVar     = "wordsRetryMaxBackoff"
+	wordsRefreshIntervalEnvVar     = "wordsRefreshInterval"
+)
+
+// wordListState is the word list, or the reason it is unavailable
+type wordListState struct {
+	words WordList
+	err   error
+}
 
-	var wordMap map[string][]string
+// wordLoader holds the word list.  Should loading it fail it is retried in
+// the background, with backoff, until it succeeds, and in the meantime get
+// returns the reason it is unavailable.  Once loaded it is refreshed every
+// refresh, if set, with the new list swapped in whole so requests always see
+// one list or the other, and onChange told when its contents change.
+type wordLoader struct {
+	source   WordSource
+	retry    retryPolicy
+	refresh  time.Duration
+	onChange func(old WordList, new WordList)
+
+	state atomic.Value // *wordListState
+	start sync.Once
+}
 
-	var netClient = &http.Client{
-		Timeout: time.Second * 3,
+// words is the word list requests are answered from
+var words = newWordLoader(&envWordSource{})
+
+func newWordLoader(source WordSource) *wordLoader {
+
+	l := &wordLoader{
+		source: source,
+		retry: retryPolicy{
+			initialBackoff: envDuration(wordsRetryInitialBackoffEnvVar, time.Second),
+			maxBackoff:     envDuration(wordsRetryMaxBackoffEnvVar, time.Minute),
+		},
+		refresh:  envDuration(wordsRefreshIntervalEnvVar, 5*time.Minute),
+		onChange: sendWordListUpdated,
 	}
+	l.state.Store(&wordListState{err: errors.New("the word list has not been loaded yet")})
+
+	return l
+}
 
-	wordsURL := os.Getenv(wordsURLEnvVar)
+// load makes a first attempt to load the word list, leaving further attempts
+// and refreshes to the background
+func (l *wordLoader) load() {
 
-	if len(wordsURL) <= 0 {
-		log.Panic("wordsURL env var not set or empty")
+	l.start.Do(func() {
+		go l.run(l.attempt())
+	})
+}
+
+func (l *wordLoader) run(loaded bool) {
+
+	for attempts := 1; !loaded; attempts++ {
+		time.Sleep(l.retry.backoff(attempts))
+		loaded = l.attempt()
 	}
 
-	resp, getErr := netClient.Get(wordsURL)
+	if l.refresh <= 0 {
+		return
+	}
 
-	if getErr != nil {
-		panic(getErr.Error())
+	for {
+		time.Sleep(l.refresh)
+		l.attempt()
 	}
+}
+
+// attempt loads the word list once, reporting whether it succeeded.  A list
+// already loaded is kept should loading a newer one fail.
+func (l *wordLoader) attempt() bool {
 
-	body, readErr := ioutil.ReadAll(resp.Body)
-	if readErr != nil {
-		panic(readErr.Error())
+	current := l.state.Load().(*wordListState)
+
+	wordMap, err := l.source.Words()
+	if err != nil {
+		if current.words != nil {
+			log.Printf("unable to refresh word list, keeping the current one: %s", err)
+		} else {
+			log.Printf("unable to load word list: %s", err)
+			l.state.Store(&wordListState{err: err})
+		}
+		return false
 	}
 
-	parseErr := json.Unmarshal(body, &wordMap)
-	if parseErr != nil {
-		panic(parseErr.Error())
+	l.state.Store(&wordListState{words: wordMap})
+
+	if current.words != nil && l.onChange != nil && !reflect.DeepEqual(current.words, wordMap) {
+		l.onChange(current.words, wordMap)
 	}
+	return true
+}
+
+// get returns the word list, or an error saying why it is unavailable
+func (l *wordLoader) get() (WordList, error) {
+
+	state := l.state.Load().(*wordListState)
+
+	if state.err != nil {
+		return nil, fmt.Errorf("the word list is unavailable: %s", state.err)
+	}
+	return state.words, nil
+}
 
-	return wordMap
+// Word is an entry of the word list.  A word is picked in proportion to its
+// weight, 1 unless given, from among those carrying any tags and locale the
+// request asks for.
+type Word struct {
+	Word   string   `json:"word"`
+	Weight *float64 `json:"weight,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Locale string   `json:"locale,omitempty"`
 }
 
-func getWordValue(wordList []string) map[string]string {
+// WordList maps each word type, such as noun, to its words
+type WordList map[string][]Word
+
+// UnmarshalJSON reads a word given either as a plain string or as an object
+func (w *Word) UnmarshalJSON(b []byte) error {
+
+	var plain string
+	if err := json.Unmarshal(b, &plain); err == nil {
+		*w = Word{Word: plain}
+		return nil
+	}
+
+	type word Word
+	if err := json.Unmarshal(b, (*word)(w)); err != nil {
+		return err
+	}
 
-	if listLength := len(wordList) - 1; listLength > 0 {
-		return map[string]string{"word": wordList[rand.Intn(listLength)]}
+	if len(w.Word) == 0 {
+		return errors.New("word has no word member")
+	}
+	if w.Weight != nil && *w.Weight < 0 {
+		return fmt.Errorf("word %q has a negative weight", w.Word)
 	}
 	return nil
 }
+
+func (w *Word) weight() float64 {
+
+	if w.Weight == nil {
+		return 1
+	}
+	return *w.Weight
+}
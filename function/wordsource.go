@@ -0,0 +1,247 @@
+package function
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errWordsURLUnset = errors.New(wordsURLEnvVar + " env var not set or empty")
+
+// WordSource supplies the word list
+type WordSource interface {
+	Words() (WordList, error)
+}
+
+// wordSourceFunc adapts a function to a WordSource
+type wordSourceFunc func() (WordList, error)
+
+func (f wordSourceFunc) Words() (WordList, error) {
+	return f()
+}
+
+// newWordSource returns the source of the word list at rawURL, chosen by its
+// scheme:
+//
+//	http://, https://   a JSON object of word type to words, see parseWordList
+//	file:///words.json  a local file holding the same
+//	file:///words/      a local directory of <type>.txt files, one word per line
+//	embed://default     a list compiled into the function
+func newWordSource(rawURL string) (WordSource, error) {
+
+	if len(rawURL) == 0 {
+		return nil, errWordsURLUnset
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &httpWordSource{
+			url:    rawURL,
+			client: &http.Client{Timeout: time.Second * 3},
+		}, nil
+	case "file":
+		path := u.Path
+		if len(u.Host) > 0 && u.Host != "localhost" {
+			// file://words.json, taken as relative to the working directory
+			path = u.Host + u.Path
+		}
+		return &fileWordSource{path: path}, nil
+	case "embed":
+		embedded, ok := embeddedWordLists[u.Host]
+		if !ok {
+			return nil, fmt.Errorf("no compiled-in word list %q", u.Host)
+		}
+
+		wordMap := make(WordList, len(embedded))
+		for wordType, wordList := range embedded {
+			for _, w := range wordList {
+				wordMap[wordType] = append(wordMap[wordType], Word{Word: w})
+			}
+		}
+		return wordSourceFunc(func() (WordList, error) {
+			return wordMap, nil
+		}), nil
+	}
+
+	return nil, fmt.Errorf("unsupported word source scheme %q", u.Scheme)
+}
+
+// envWordSource is the source named by wordsURL.  The source is kept between
+// loads, so that it can remember what it last fetched, until wordsURL changes.
+type envWordSource struct {
+	mu     sync.Mutex
+	url    string
+	source WordSource
+}
+
+func (s *envWordSource) Words() (WordList, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if wordsURL := os.Getenv(wordsURLEnvVar); s.source == nil || wordsURL != s.url {
+		source, err := newWordSource(wordsURL)
+		if err != nil {
+			return nil, err
+		}
+		s.url, s.source = wordsURL, source
+	}
+
+	return s.source.Words()
+}
+
+// httpWordSource fetches the word list over HTTP.  Having fetched it once it
+// makes conditional requests, reusing the list it has when the server says it
+// hasn't been modified.
+type httpWordSource struct {
+	url    string
+	client *http.Client
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+	words        WordList
+}
+
+func (s *httpWordSource) Words() (WordList, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.words != nil {
+		if len(s.etag) > 0 {
+			req.Header.Set("If-None-Match", s.etag)
+		}
+		if len(s.lastModified) > 0 {
+			req.Header.Set("If-Modified-Since", s.lastModified)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && s.words != nil {
+		return s.words, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", s.url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	wordMap, err := parseWordList(s.url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+	s.words = wordMap
+
+	return wordMap, nil
+}
+
+// fileWordSource reads the word list from a local JSON file, or from a
+// directory holding a text file per word type
+type fileWordSource struct {
+	path string
+}
+
+func (s *fileWordSource) Words() (WordList, error) {
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return readWordDir(s.path)
+	}
+
+	body, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseWordList(s.path, body)
+}
+
+// readWordDir reads each <type>.txt file in dir as the words of that type, one
+// per line, ignoring blank lines and those starting with #
+func readWordDir(dir string) (WordList, error) {
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+
+	wordMap := make(WordList)
+
+	for _, file := range files {
+		body, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		wordType := strings.TrimSuffix(filepath.Base(file), ".txt")
+
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			if word := strings.TrimSpace(scanner.Text()); len(word) > 0 && !strings.HasPrefix(word, "#") {
+				wordMap[wordType] = append(wordMap[wordType], Word{Word: word})
+			}
+		}
+	}
+
+	if len(wordMap) == 0 {
+		return nil, fmt.Errorf("%s holds no words", dir)
+	}
+
+	return wordMap, nil
+}
+
+// parseWordList decodes a JSON object of word type to words read from name.
+// Each word is either a string or an object giving its weight, tags and
+// locale as well.
+func parseWordList(name string, body []byte) (WordList, error) {
+
+	var wordMap WordList
+
+	if err := json.Unmarshal(body, &wordMap); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", name, err)
+	}
+
+	if len(wordMap) == 0 {
+		return nil, fmt.Errorf("%s holds no words", name)
+	}
+
+	return wordMap, nil
+}